@@ -9,6 +9,7 @@ require (
 	github.com/gorilla/websocket v1.4.2
 	github.com/pion/dtls/v2 v2.0.9
 	github.com/pion/interceptor v0.0.13
+	github.com/pion/rtp v1.6.5
 	github.com/pion/sdp/v2 v2.4.0
 	github.com/pion/webrtc/v3 v3.0.31
 )
@@ -5,6 +5,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/alexflint/go-arg"
@@ -17,22 +18,29 @@ import (
 )
 
 type Args struct {
-	Count       int    `arg:"-c" default:"1" help:"Count instances"`
-	Invite      string `arg:"-i" placeholder:"NUMBER" help:"Number for invite"`
-	Username    string `default:"101"`
-	Password    string `default:"101"`
-	Domain      string `default:"local"`
-	Transport   string `default:"ws"`
-	Host        string `default:"192.168.100.10"`
-	Path        string `help:"Path in server, for examples /webrtc/socket"`
-	Port        uint16 `default:"5071"`
-	SaveToFile  bool   `arg:"-s" default:"false" help:"Save media to file in ogg format --outfilename"`
-	OutFileName string `placeholder:"FILENAME" default:"output.ogg"`
-	InFileName  string `placeholder:"FILENAME" help:"Play ogg file in channel, example: --infilename input.ogg"`
-	SRTPKey     string `default:"certs/dtls-srtp.pem" placeholder:"PATH"`
-	SRTPCert    string `default:"certs/dtls-srtp.pub.pem" placeholder:"PATH"`
-	Progress    bool   `arg:"-p" default:"false" help:"Display rtp progress"`
-	Verbose     bool   `arg:"-v" default:"false" help:"Verbose"`
+	Count       int           `arg:"-c" default:"1" help:"Count instances"`
+	Invite      string        `arg:"-i" placeholder:"NUMBER" help:"Number for invite"`
+	Username    string        `default:"101"`
+	Password    string        `default:"101"`
+	Domain      string        `default:"local"`
+	Transport   string        `default:"ws"`
+	Host        string        `default:"192.168.100.10"`
+	Path        string        `help:"Path in server, for examples /webrtc/socket"`
+	Port        uint16        `default:"5071"`
+	SaveToFile  bool          `arg:"-s" default:"false" help:"Save media to file in ogg format --outfilename"`
+	OutFileName string        `placeholder:"FILENAME" default:"output.ogg"`
+	InFileName  string        `placeholder:"FILENAME" help:"Play ogg file in channel, example: --infilename input.ogg"`
+	SRTPKey     string        `default:"certs/dtls-srtp.pem" placeholder:"PATH"`
+	SRTPCert    string        `default:"certs/dtls-srtp.pub.pem" placeholder:"PATH"`
+	Progress    bool          `arg:"-p" default:"false" help:"Display rtp progress"`
+	Verbose     bool          `arg:"-v" default:"false" help:"Verbose"`
+	UserAgent   string        `default:"github.com/evgeniy-klemin/webrtc-sip-client" help:"User-Agent header value"`
+	Hangup      time.Duration `placeholder:"DURATION" help:"Hang up the call after duration, example: --hangup 30s"`
+	Subscribe   string        `placeholder:"NUMBER" help:"Subscribe to dialog state of number (BLF)"`
+	MWI         bool          `default:"false" help:"Subscribe to message waiting indication"`
+	DTMF        string        `placeholder:"DIGITS" help:"Send DTMF digits when the call is answered, example: --dtmf 1234#"`
+	DTMFMode    string        `placeholder:"MODE" default:"rfc4733" help:"How DTMF is sent: rfc4733, info-relay or info"`
+	Tones       bool          `default:"false" help:"Detect in-band DTMF and call progress tones, the other side is asked to send G.711"`
 }
 
 func main() {
@@ -50,7 +58,18 @@ func main() {
 	select {}
 }
 
+var dtmfModes = map[string]softphone.DTMFMode{
+	"rfc4733":    softphone.DTMFModeRFC4733,
+	"info-relay": softphone.DTMFModeInfoRelay,
+	"info":       softphone.DTMFModeInfo,
+}
+
 func softPhone(args *Args, cert webrtc.Certificate) {
+	dtmfMode, ok := dtmfModes[args.DTMFMode]
+	if !ok {
+		log.Printf("unknown DTMF mode %q", args.DTMFMode)
+		return
+	}
 	phone := softphone.New(softphone.Options{
 		Username:  args.Username,
 		Password:  args.Password,
@@ -60,20 +79,87 @@ func softPhone(args *Args, cert webrtc.Certificate) {
 		Path:      args.Path,
 		Port:      args.Port,
 		Verbose:   args.Verbose,
+		UserAgent: args.UserAgent,
+		DTMFMode:  dtmfMode,
+		G711:      args.Tones,
 	}, cert)
-	phone.Register()
+	if err := phone.Register(); err != nil {
+		log.Println(err)
+		return
+	}
 
 	inviteCount := 0
 
-	phone.OnInvite = func(inviteMessage softphone.SipMessage) {
+	phone.OnHangup = func(call *softphone.Call, reason softphone.HangupReason) {
+		fmt.Printf("Hangup: method=%s remote=%t reason=%q\n", reason.Method, reason.Remote, reason.Reason)
+	}
+
+	phone.OnProgress = func(call *softphone.Call, response softphone.SipMessage) {
+		fmt.Printf("Progress: %s early media=%t\n", response.Subject, response.Body != "")
+	}
+
+	phone.OnHold = func(call *softphone.Call, hold bool) {
+		fmt.Printf("Hold: %t\n", hold)
+	}
+
+	phone.OnTransferProgress = func(call *softphone.Call, statusCode int, reason string) {
+		fmt.Printf("Transfer: %d %s\n", statusCode, reason)
+	}
+
+	phone.OnTransfer = func(call *softphone.Call, newCall *softphone.Call) {
+		fmt.Printf("Transferred to %s\n", newCall.Dialog().RemoteURI)
+		go hangupAfter(newCall, args.Hangup)
+	}
+
+	phone.OnReplace = func(call *softphone.Call, newCall *softphone.Call) {
+		fmt.Printf("Replaced by call %s\n", newCall.Dialog().CallID)
+		go hangupAfter(newCall, args.Hangup)
+	}
+
+	phone.OnRedirect = func(call *softphone.Call, response softphone.SipMessage, target softphone.NameAddr) bool {
+		fmt.Printf("Redirected by %d %s to %s\n", response.StatusCode(), response.ReasonPhrase(), target.URI)
+		return true
+	}
+
+	phone.OnMessage = func(message softphone.SipMessage) {
+		fmt.Printf("Message from %s: %s\n", message.Headers.Get("From"), message.Body)
+	}
+
+	phone.OnNotify = func(subscription *softphone.Subscription, notify softphone.SipMessage) {
+		info, err := softphone.ParseDialogInfo(notify.Body)
+		if err != nil {
+			fmt.Printf("Notify: %s %s\n", subscription.Event(), notify.Headers.Get("Subscription-State"))
+			return
+		}
+		fmt.Printf("BLF %s: ringing=%t busy=%t\n", subscription.Target(), info.Ringing(), info.Busy())
+	}
+
+	phone.OnMessageWaiting = func(summary softphone.MessageSummary) {
+		voice := summary.Voice()
+		fmt.Printf("Messages waiting: %t voice=%d/%d\n", summary.MessagesWaiting, voice.New, voice.Old)
+	}
+
+	phone.OnDTMF = func(call *softphone.Call, digit rune, duration time.Duration) {
+		fmt.Printf("DTMF: %c %s\n", digit, duration)
+	}
+
+	phone.OnInvite = func(request *softphone.ServerTransaction) {
 		inviteCount++
 		if inviteCount > 1 {
+			if err := request.Reply(486, "Busy Here"); err != nil {
+				log.Println(err)
+			}
 			return
 		}
-		phone.Answer(inviteMessage)
+		call, err := phone.Answer(request)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		hangupAfter(call, args.Hangup)
 	}
 
-	phone.OnTrack = func(remote *webrtc.TrackRemote, local *webrtc.TrackLocalStaticSample) {
+	phone.OnTrack = func(remote *softphone.RemoteTrack, local *softphone.AudioTrack) {
 		fmt.Println("=======================================================================")
 		fmt.Println("OnTrack")
 		fmt.Println("=======================================================================")
@@ -84,6 +170,11 @@ func softPhone(args *Args, cert webrtc.Certificate) {
 				return
 			}
 			time.Sleep(5 * time.Second)
+			// the ogg file has Opus, the other side may accept only G.711
+			if codec := local.Codec(); !strings.EqualFold(codec.MimeType, webrtc.MimeTypeOpus) {
+				log.Printf("%s isn't played, the call has %s audio", args.InFileName, codec.MimeType)
+				return
+			}
 			fileName := args.InFileName
 			f, err := os.OpenFile(fileName, os.O_RDONLY, 0600)
 			if err != nil {
@@ -128,14 +219,34 @@ func softPhone(args *Args, cert webrtc.Certificate) {
 			}
 		}
 
-		defer oggFile.Close()
+		if oggFile != nil {
+			defer oggFile.Close()
+		}
+
+		var detector *softphone.ToneDetector
+		if args.Tones {
+			decoder, err := softphone.NewDecoder(remote.Codec())
+			if err != nil {
+				log.Println(err)
+			} else {
+				detector = softphone.NewToneDetector(decoder)
+				detector.OnDTMF = func(digit rune, duration time.Duration) {
+					fmt.Printf("In-band DTMF %c, %s\n", digit, duration)
+				}
+				detector.OnTone = func(tone softphone.Tone) {
+					fmt.Printf("Tone: %s\n", tone)
+				}
+				defer detector.Close()
+			}
+		}
 		var startNum uint16
 		var size int
 		t1 := time.Now()
 		for {
 			rtp, _, err := remote.ReadRTP()
 			if err != nil {
-				log.Fatal(err)
+				log.Println(err)
+				return
 			}
 			if startNum == 0 {
 				startNum = rtp.SequenceNumber
@@ -156,14 +267,56 @@ func softPhone(args *Args, cert webrtc.Certificate) {
 					panic(err)
 				}
 			}
+			if detector != nil {
+				if err := detector.WriteRTP(rtp); err != nil {
+					log.Println(err)
+				}
+			}
 		}
 	}
 
 	time.Sleep(time.Second * 2)
 
+	if args.Subscribe != "" {
+		if _, err := phone.Subscribe(args.Subscribe, "dialog", "application/dialog-info+xml", 0); err != nil {
+			log.Println(err)
+		}
+	}
+
+	if args.MWI {
+		if _, err := phone.SubscribeMessageWaiting(0); err != nil {
+			log.Println(err)
+		}
+	}
+
 	if args.Invite != "" {
-		phone.Invite(args.Invite)
+		call, err := phone.Invite(args.Invite)
+		if err != nil {
+			log.Println(err)
+		} else {
+			if args.DTMF != "" {
+				if err := call.SendDTMF(args.DTMF, 0); err != nil {
+					log.Println(err)
+				}
+			}
+			hangupAfter(call, args.Hangup)
+		}
 	}
 
 	select {}
 }
+
+// hangupAfter hang up the call after duration, 0 keeps the call
+func hangupAfter(call *softphone.Call, duration time.Duration) {
+	if duration == 0 {
+		return
+	}
+	select {
+	case <-time.After(duration):
+	case <-call.Done():
+		return
+	}
+	if err := call.Hangup(); err != nil {
+		log.Println(err)
+	}
+}
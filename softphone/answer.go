@@ -2,46 +2,37 @@ package softphone
 
 import (
 	"fmt"
-	"strings"
+	"log"
 
-	"github.com/google/uuid"
 	"github.com/pion/interceptor"
+	"github.com/pion/sdp/v2"
 	"github.com/pion/webrtc/v3"
 )
 
-// Answer answer an incoming call
-func (s *Softphone) Answer(inviteMessage SipMessage) {
-
-	var responseMessage SipMessage
-
-	responseMessage = SipMessage{
-		Subject: "SIP/2.0 100 Trying",
-		Headers: map[string]string{
-			"Via":       inviteMessage.Headers["Via"],
-			"From":      inviteMessage.Headers["From"],
-			"CSeq":      inviteMessage.Headers["CSeq"],
-			"Call-ID":   inviteMessage.Headers["Call-ID"],
-			"To":        inviteMessage.Headers["To"],
-			"Supported": "outbound",
-		},
-		Body: "",
-	}
-	s.Send(responseMessage, nil)
-
-	responseMessage = SipMessage{
-		Subject: "SIP/2.0 180 Ringing",
-		Headers: map[string]string{
-			"Contact":   fmt.Sprintf("<sip:%s;transport=%s>", fakeEmail, strings.ToLower(s.options.Transport)),
-			"Via":       inviteMessage.Headers["Via"],
-			"From":      inviteMessage.Headers["From"],
-			"CSeq":      inviteMessage.Headers["CSeq"],
-			"Call-ID":   inviteMessage.Headers["Call-ID"],
-			"To":        fmt.Sprintf("%s;tag=%s", inviteMessage.Headers["To"], uuid.New().String()),
-			"Supported": "outbound",
-		},
-		Body: "",
-	}
-	s.Send(responseMessage, nil)
+// Answer answer an incoming call, returns when the call is answered
+func (s *Softphone) Answer(request *ServerTransaction) (*Call, error) {
+	return s.answer(request, nil)
+}
+
+// answer answer INVITE, the INVITE replacing a call is answered without ringing and takes the local track of the call
+func (s *Softphone) answer(request *ServerTransaction, replaced *Call) (*Call, error) {
+	inviteMessage := request.Request()
+	if rejected, err := rejectSmallInterval(request); rejected {
+		if err == nil {
+			err = ErrSessionIntervalTooSmall
+		}
+		return nil, err
+	}
+
+	to, err := inviteMessage.To()
+	if err != nil {
+		return nil, rejectBadInvite(request, err)
+	}
+	to.Params.Set("tag", request.localTag())
+	dialog, err := newIncomingDialog(inviteMessage, to.Tag())
+	if err != nil {
+		return nil, rejectBadInvite(request, err)
+	}
 
 	mediaEngine := webrtc.MediaEngine{}
 	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
@@ -50,6 +41,10 @@ func (s *Softphone) Answer(inviteMessage SipMessage) {
 	}, webrtc.RTPCodecTypeAudio); err != nil {
 		panic(err)
 	}
+	registerTelephoneEvent(&mediaEngine)
+	if s.options.G711 {
+		registerG711(&mediaEngine)
+	}
 
 	i := &interceptor.Registry{}
 	if err := webrtc.RegisterDefaultInterceptors(&mediaEngine, i); err != nil {
@@ -66,13 +61,41 @@ func (s *Softphone) Answer(inviteMessage SipMessage) {
 		panic(err)
 	}
 
+	// media sent to the replaced call goes to the new call
+	localTrack := newAudioTrack("audio", "go")
+	if replaced != nil && replaced.audioTrack != nil {
+		localTrack = replaced.audioTrack
+	}
+
+	// the call is known to the transaction, so CANCEL can end it while it is being answered
+	call := newCall(s, dialog, false, peerConnection, localTrack)
+	request.mu.Lock()
+	request.call = call
+	request.mu.Unlock()
+
+	// INVITE with Replaces is accepted without alerting the user, RFC 3891 3
+	if replaced == nil {
+		responseMessage := NewResponse(inviteMessage, 180, "Ringing")
+		responseMessage.Headers.Set("To", to.String())
+		responseMessage.Headers = append(responseMessage.Headers, inviteMessage.Headers.Filter("Record-Route")...)
+		responseMessage.Headers.Add("Contact", s.contact().String())
+		responseMessage.Headers.Add("Supported", "outbound")
+		respond := request.Respond
+		if inviteMessage.HasOptionTag("Supported", "100rel") || inviteMessage.HasOptionTag("Require", "100rel") {
+			respond = request.RespondReliably
+		}
+		if err := respond(responseMessage); err != nil {
+			return nil, call.answerFailed(request, err)
+		}
+	}
+
 	peerConnection.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
 		fmt.Printf(">>> OnICEConnectionStateChange: %s <<<\n", connectionState.String())
 	})
 
 	peerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
 		if s.OnTrack != nil {
-			s.OnTrack(track, nil)
+			s.OnTrack(newRemoteTrack(track, call), localTrack)
 		}
 	})
 
@@ -88,46 +111,102 @@ func (s *Softphone) Answer(inviteMessage SipMessage) {
 		fmt.Printf(">>> OnSignalingStateChange: %s <<<\n", sign)
 	})
 
-	if _, err = peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio); err != nil {
-		panic(err)
+	if _, err = peerConnection.AddTrack(localTrack); err != nil {
+		return nil, call.rejectOffer(request, to, 500, "Server Internal Error", err)
 	}
 
 	offer := webrtc.SessionDescription{
 		Type: webrtc.SDPTypeOffer,
 		SDP:  patchFreeSwitchSDP(inviteMessage.Body),
 	}
-	err = peerConnection.SetRemoteDescription(offer)
-	if err != nil {
-		panic(err)
+	if s.options.G711 {
+		offer.SDP = preferG711(offer.SDP)
+	}
+	// the offer is remote input, an offer which can't be answered rejects the call
+	if err := peerConnection.SetRemoteDescription(offer); err != nil {
+		code, reason := offerErrorStatus(inviteMessage.Body)
+		return nil, call.rejectOffer(request, to, code, reason, err)
 	}
 
 	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
 
 	answer, err := peerConnection.CreateAnswer(nil)
 	if err != nil {
-		panic(err)
+		return nil, call.rejectOffer(request, to, 488, "Not Acceptable Here", err)
 	}
-	err = peerConnection.SetLocalDescription(answer)
-	if err != nil {
-		panic(err)
+	if err := peerConnection.SetLocalDescription(answer); err != nil {
+		return nil, call.rejectOffer(request, to, 488, "Not Acceptable Here", err)
+	}
+
+	select {
+	case <-gatherComplete:
+	case <-call.Done():
+		return nil, call.answerFailed(request, ErrAlreadyAnswered)
+	}
+
+	responseMessage := NewResponse(inviteMessage, 200, "OK")
+	responseMessage.Headers.Set("To", to.String())
+	responseMessage.Headers = append(responseMessage.Headers, inviteMessage.Headers.Filter("Record-Route")...)
+	responseMessage.Headers.Add("Contact", s.contact().String())
+	responseMessage.Headers.Add("Content-Type", "application/sdp")
+	responseMessage.Headers.Add("Allow", allowMethods)
+	responseMessage.Body = peerConnection.LocalDescription().SDP
+	startSessionTimer := call.sessionTimerForRequest(inviteMessage, &responseMessage)
+	call.mu.Lock()
+	call.remoteAllowsUpdate = inviteMessage.HasOptionTag("Allow", "UPDATE")
+	call.mu.Unlock()
+
+	call.confirm()
+	if err := request.Respond(responseMessage); err != nil {
+		return nil, call.answerFailed(request, err)
 	}
+	startSessionTimer()
+	return call, nil
+}
 
-	<-gatherComplete
+// answerFailed end the call whose INVITE can't be answered, returns the error of answer: ErrPrackTimeout when
+// the INVITE was rejected with 504 because of missing PRACK, ErrCallCanceled when it was canceled
+func (c *Call) answerFailed(t *ServerTransaction, err error) error {
+	if err != ErrAlreadyAnswered {
+		c.terminate(HangupReason{Remote: true, Method: "CANCEL"})
+		return err
+	}
+	t.mu.Lock()
+	prackTimedOut := t.prackTimedOut
+	t.mu.Unlock()
+	if prackTimedOut {
+		c.terminate(prackTimeoutHangup)
+		return ErrPrackTimeout
+	}
+	c.terminate(HangupReason{Remote: true, Method: "CANCEL"})
+	return ErrCallCanceled
+}
 
-	responseMessage = SipMessage{
-		Subject: "SIP/2.0 200 OK",
-		Headers: map[string]string{
-			"Contact":      fmt.Sprintf("<sip:%s;transport=%s>", fakeEmail, strings.ToLower(s.options.Transport)),
-			"Content-Type": "application/sdp",
-			"Via":          inviteMessage.Headers["Via"],
-			"From":         inviteMessage.Headers["From"],
-			"CSeq":         inviteMessage.Headers["CSeq"],
-			"Call-ID":      inviteMessage.Headers["Call-ID"],
-			"To":           fmt.Sprintf("%s;tag=%s", inviteMessage.Headers["To"], uuid.New().String()),
-			"Allow":        "ACK,BYE,CANCEL,INFO,INVITE,MESSAGE,NOTIFY,OPTIONS,PRACK,REFER,REGISTER,SUBSCRIBE",
-		},
-		Body: peerConnection.LocalDescription().SDP,
+// rejectBadInvite reply 400 to INVITE which can't start a dialog, e.g. without Contact, returns err
+func rejectBadInvite(t *ServerTransaction, err error) error {
+	if replyErr := t.Reply(400, "Bad Request"); replyErr != nil {
+		log.Println(replyErr)
 	}
+	return err
+}
 
-	s.Send(responseMessage, nil)
+// offerErrorStatus status of final response to INVITE with offer which can't be answered:
+// 400 for SDP which can't be parsed, 488 for SDP without acceptable media
+func offerErrorStatus(body string) (int, string) {
+	parsed := &sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(body)); err != nil {
+		return 400, "Bad Request"
+	}
+	return 488, "Not Acceptable Here"
+}
+
+// rejectOffer reply final response to INVITE which can't be answered and end the call, returns err
+func (c *Call) rejectOffer(t *ServerTransaction, to NameAddr, code int, reason string, err error) error {
+	response := NewResponse(t.Request(), code, reason)
+	response.Headers.Set("To", to.String())
+	if respondErr := t.Respond(response); respondErr != nil && respondErr != ErrAlreadyAnswered {
+		log.Println(respondErr)
+	}
+	c.terminate(HangupReason{Method: "INVITE", Reason: fmt.Sprintf("SIP;cause=%d;text=%q", code, reason)})
+	return err
 }
@@ -0,0 +1,362 @@
+package softphone
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// mimeTypeTelephoneEvent MIME type of DTMF events, RFC 4733
+const mimeTypeTelephoneEvent = "audio/telephone-event"
+
+// DefaultDTMFDuration duration of DTMF digit sent by SendDTMF if duration is 0
+const DefaultDTMFDuration = 100 * time.Millisecond
+
+// dtmfInterval interval of telephone-event packets, the usual packet time of Opus and G.711
+const dtmfInterval = 20 * time.Millisecond
+
+// dtmfGap pause between DTMF digits
+const dtmfGap = 50 * time.Millisecond
+
+// dtmfVolume power level of DTMF tone, -10 dBm0
+const dtmfVolume = 10
+
+// dtmfEndPackets number of sent final packets of event, RFC 4733 2.5.1.4
+const dtmfEndPackets = 3
+
+// ErrInvalidDTMF digit isn't one of 0-9, *, #, A-D
+var ErrInvalidDTMF = errors.New("invalid DTMF digit")
+
+// ErrDTMFNotNegotiated the other side doesn't accept telephone-event
+var ErrDTMFNotNegotiated = errors.New("telephone-event is not negotiated")
+
+// registerTelephoneEvent telephone-event with the clock rate of Opus and with 8000 of G.711 and SIP gateways,
+// events share the clock of the audio. The payload types are the ones of browsers, they are not used
+// by the default codecs of pion.
+func registerTelephoneEvent(mediaEngine *webrtc.MediaEngine) {
+	for _, codec := range []webrtc.RTPCodecParameters{
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeTelephoneEvent, ClockRate: 48000, SDPFmtpLine: "0-16"},
+			PayloadType:        110,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeTelephoneEvent, ClockRate: 8000, SDPFmtpLine: "0-16"},
+			PayloadType:        126,
+		},
+	} {
+		if err := mediaEngine.RegisterCodec(codec, webrtc.RTPCodecTypeAudio); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// AudioTrack local audio track of a call, Opus or G.711 if the other side doesn't accept Opus.
+// DTMF is sent as RFC 4733 telephone-events in the same RTP stream.
+type AudioTrack struct {
+	id       string
+	streamID string
+
+	mu       sync.Mutex
+	bindings []*audioTrackBinding
+	// bound is closed after the first Bind, successful or not, then bindings tell whether media can be sent
+	bound     chan struct{}
+	boundOnce sync.Once
+	// dtmf is held while digits are sent
+	dtmf sync.Mutex
+	// held the call is on hold, samples and events aren't sent
+	held bool
+}
+
+// audioTrackBinding RTP stream of the track in one PeerConnection
+type audioTrackBinding struct {
+	id               string
+	ssrc             uint32
+	codec            webrtc.RTPCodecParameters
+	payloadType      uint8
+	eventPayloadType uint8
+	hasEvents        bool
+	clockRate        uint32
+	writeStream      webrtc.TrackLocalWriter
+	sequencer        rtp.Sequencer
+	// timestamp RTP timestamp of the next sample
+	timestamp uint32
+	// sampleEnd when the last sent sample ends playing, the timestamp follows the clock during silence
+	sampleEnd time.Time
+	// event timestamp of the event being sent, samples are dropped during the event
+	event      bool
+	eventStart uint32
+}
+
+func newAudioTrack(id, streamID string) *AudioTrack {
+	return &AudioTrack{id: id, streamID: streamID, bound: make(chan struct{})}
+}
+
+// ID identifier of the track
+func (t *AudioTrack) ID() string {
+	return t.id
+}
+
+// StreamID identifier of the stream of the track
+func (t *AudioTrack) StreamID() string {
+	return t.streamID
+}
+
+// Kind audio
+func (t *AudioTrack) Kind() webrtc.RTPCodecType {
+	return webrtc.RTPCodecTypeAudio
+}
+
+// Bind start RTP stream in the PeerConnection with negotiated Opus, otherwise PCMU or PCMA,
+// and telephone-event of the same clock rate
+func (t *AudioTrack) Bind(ctx webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	defer t.boundOnce.Do(func() { close(t.bound) })
+	codecs := ctx.CodecParameters()
+	codec := sendCodec(codecs)
+	if codec == nil {
+		return webrtc.RTPCodecParameters{}, webrtc.ErrUnsupportedCodec
+	}
+
+	binding := &audioTrackBinding{
+		id:          ctx.ID(),
+		ssrc:        uint32(ctx.SSRC()),
+		codec:       *codec,
+		payloadType: uint8(codec.PayloadType),
+		clockRate:   codec.ClockRate,
+		writeStream: ctx.WriteStream(),
+		sequencer:   rtp.NewRandomSequencer(),
+		timestamp:   rand.Uint32(),
+		sampleEnd:   time.Now(),
+	}
+	for _, event := range codecs {
+		if strings.EqualFold(event.MimeType, mimeTypeTelephoneEvent) && event.ClockRate == codec.ClockRate {
+			binding.eventPayloadType = uint8(event.PayloadType)
+			binding.hasEvents = true
+			break
+		}
+	}
+
+	t.mu.Lock()
+	t.bindings = append(t.bindings, binding)
+	t.mu.Unlock()
+	return *codec, nil
+}
+
+// sendCodec codec of the track among negotiated codecs: Opus, otherwise the first of PCMU and PCMA
+func sendCodec(codecs []webrtc.RTPCodecParameters) *webrtc.RTPCodecParameters {
+	for i := range codecs {
+		if strings.EqualFold(codecs[i].MimeType, webrtc.MimeTypeOpus) {
+			return &codecs[i]
+		}
+	}
+	for i := range codecs {
+		if strings.EqualFold(codecs[i].MimeType, webrtc.MimeTypePCMU) || strings.EqualFold(codecs[i].MimeType, webrtc.MimeTypePCMA) {
+			return &codecs[i]
+		}
+	}
+	return nil
+}
+
+// Codec codec of samples written by WriteSample, empty until the track is bound
+func (t *AudioTrack) Codec() webrtc.RTPCodecParameters {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.bindings) == 0 {
+		return webrtc.RTPCodecParameters{}
+	}
+	return t.bindings[0].codec
+}
+
+// Unbind stop RTP stream in the PeerConnection
+func (t *AudioTrack) Unbind(ctx webrtc.TrackLocalContext) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, binding := range t.bindings {
+		if binding.id == ctx.ID() {
+			t.bindings = append(t.bindings[:i], t.bindings[i+1:]...)
+			return nil
+		}
+	}
+	return webrtc.ErrUnbindFailed
+}
+
+// WriteSample send sample of Codec in one RTP packet, samples are dropped while DTMF is sent or the call is on hold
+func (t *AudioTrack) WriteSample(sample media.Sample) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	var err error
+	for _, binding := range t.bindings {
+		binding.follow(now)
+		if !binding.event && !t.held {
+			// skip packets by the number of previously dropped packets
+			for i := uint16(0); i < sample.PrevDroppedPackets; i++ {
+				binding.sequencer.NextSequenceNumber()
+			}
+			header := &rtp.Header{
+				Version:        2,
+				PayloadType:    binding.payloadType,
+				SequenceNumber: binding.sequencer.NextSequenceNumber(),
+				Timestamp:      binding.timestamp,
+				SSRC:           binding.ssrc,
+			}
+			if _, writeErr := binding.writeStream.WriteRTP(header, sample.Data); writeErr != nil && err == nil {
+				err = writeErr
+			}
+		}
+		binding.timestamp += uint32(sample.Duration.Seconds() * float64(binding.clockRate))
+		binding.sampleEnd = binding.sampleEnd.Add(sample.Duration)
+	}
+	return err
+}
+
+// setHeld stop or resume sending, the timestamp follows the clock while the call is on hold
+func (t *AudioTrack) setHeld(held bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.held = held
+}
+
+// follow advance the timestamp by the silence since the last sample
+func (b *audioTrackBinding) follow(now time.Time) {
+	if now.After(b.sampleEnd) {
+		b.timestamp += uint32(now.Sub(b.sampleEnd).Seconds() * float64(b.clockRate))
+		b.sampleEnd = now
+	}
+}
+
+// dtmfDigits DTMF digits in order of their event codes, RFC 4733 3.2
+const dtmfDigits = "0123456789*#ABCD"
+
+// dtmfEvent event code of DTMF digit
+func dtmfEvent(digit rune) (uint8, error) {
+	event := strings.IndexRune(dtmfDigits, unicode.ToUpper(digit))
+	if event < 0 {
+		return 0, ErrInvalidDTMF
+	}
+	return uint8(event), nil
+}
+
+// SendDTMF send digits 0-9, *, #, A-D as telephone-events of duration, DefaultDTMFDuration if 0.
+// Returns when all digits are sent.
+func (t *AudioTrack) SendDTMF(digits string, duration time.Duration) error {
+	events := []uint8{}
+	for _, digit := range digits {
+		event, err := dtmfEvent(digit)
+		if err != nil {
+			return err
+		}
+		events = append(events, event)
+	}
+	if duration <= 0 {
+		duration = DefaultDTMFDuration
+	}
+
+	t.dtmf.Lock()
+	defer t.dtmf.Unlock()
+	t.mu.Lock()
+	hasEvents := false
+	for _, binding := range t.bindings {
+		hasEvents = hasEvents || binding.hasEvents
+	}
+	t.mu.Unlock()
+	if !hasEvents {
+		return ErrDTMFNotNegotiated
+	}
+
+	for i, event := range events {
+		if i > 0 {
+			time.Sleep(dtmfGap)
+		}
+		if err := t.sendEvent(event, duration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendEvent send packets of one event: the first one with marker, updates with growing duration every dtmfInterval
+// and the final packet dtmfEndPackets times, RFC 4733 2.5.1
+func (t *AudioTrack) sendEvent(event uint8, duration time.Duration) error {
+	start := time.Now()
+	t.mu.Lock()
+	for _, binding := range t.bindings {
+		if binding.hasEvents {
+			binding.follow(start)
+			binding.event = true
+			binding.eventStart = binding.timestamp
+		}
+	}
+	t.mu.Unlock()
+	defer t.endEvent(start.Add(duration))
+
+	ticker := time.NewTicker(dtmfInterval)
+	defer ticker.Stop()
+	marker := true
+	for ends := 0; ends < dtmfEndPackets; {
+		<-ticker.C
+		elapsed := time.Since(start)
+		end := elapsed >= duration
+		if end {
+			elapsed = duration
+			ends++
+		}
+		if err := t.writeEvent(event, elapsed, end, marker); err != nil {
+			return err
+		}
+		marker = false
+	}
+	return nil
+}
+
+// writeEvent send telephone-event packet with the event start timestamp to all bindings with telephone-event
+func (t *AudioTrack) writeEvent(event uint8, elapsed time.Duration, end bool, marker bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var err error
+	for _, binding := range t.bindings {
+		if !binding.event || t.held {
+			continue
+		}
+		// the duration field is 16 bit, longer events are cut
+		units := elapsed.Seconds() * float64(binding.clockRate)
+		if units > 0xffff {
+			units = 0xffff
+		}
+		flags := byte(dtmfVolume)
+		if end {
+			flags |= 0x80
+		}
+		header := &rtp.Header{
+			Version:        2,
+			Marker:         marker,
+			PayloadType:    binding.eventPayloadType,
+			SequenceNumber: binding.sequencer.NextSequenceNumber(),
+			Timestamp:      binding.eventStart,
+			SSRC:           binding.ssrc,
+		}
+		payload := []byte{event, flags, byte(uint16(units) >> 8), byte(uint16(units))}
+		if _, writeErr := binding.writeStream.WriteRTP(header, payload); writeErr != nil && err == nil {
+			err = writeErr
+		}
+	}
+	return err
+}
+
+// endEvent resume samples after the event, the timestamp of the next sample follows the event
+func (t *AudioTrack) endEvent(end time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, binding := range t.bindings {
+		if binding.event {
+			binding.event = false
+			binding.follow(end)
+		}
+	}
+}
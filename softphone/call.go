@@ -0,0 +1,413 @@
+package softphone
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// ErrCallNotEstablished the call has no confirmed dialog yet or is already ended
+var ErrCallNotEstablished = errors.New("call is not established")
+
+// ErrCallCanceled the call was canceled before it was answered
+var ErrCallCanceled = errors.New("call is canceled")
+
+// ErrCallNotRinging the call isn't an outgoing call waiting for the answer
+var ErrCallNotRinging = errors.New("call is not ringing")
+
+// HangupReason why the call ended
+type HangupReason struct {
+	// Remote the call was ended by the other side
+	Remote bool
+	// Method request which ended the call: BYE, CANCEL or INVITE rejected by us
+	Method string
+	// Reason values of Reason headers of the request joined by comma, RFC 3326
+	Reason string
+}
+
+// Dialog state of SIP dialog, RFC 3261 12
+type Dialog struct {
+	CallID    string
+	LocalTag  string
+	RemoteTag string
+	// LocalURI and RemoteURI are From and To of requests sent in the dialog, without tags
+	LocalURI     NameAddr
+	RemoteURI    NameAddr
+	RemoteTarget URI
+	RouteSet     []NameAddr
+	LocalSeq     uint32
+	RemoteSeq    uint32
+}
+
+// newIncomingDialog dialog of the UAS for INVITE received by us, RFC 3261 12.1.1
+func newIncomingDialog(request SipMessage, localTag string) (Dialog, error) {
+	from, err := request.From()
+	if err != nil {
+		return Dialog{}, err
+	}
+	to, err := request.To()
+	if err != nil {
+		return Dialog{}, err
+	}
+	cseq, err := request.CSeq()
+	if err != nil {
+		return Dialog{}, err
+	}
+	contacts, err := request.Contacts()
+	if err != nil {
+		return Dialog{}, err
+	}
+	if len(contacts) == 0 {
+		return Dialog{}, &ParseError{Reason: "header Contact is missing"}
+	}
+	routes, err := request.RecordRoutes()
+	if err != nil {
+		return Dialog{}, err
+	}
+	return Dialog{
+		CallID:       request.Headers.Get("Call-ID"),
+		LocalTag:     localTag,
+		RemoteTag:    from.Tag(),
+		LocalURI:     NameAddr{DisplayName: to.DisplayName, URI: to.URI},
+		RemoteURI:    NameAddr{DisplayName: from.DisplayName, URI: from.URI},
+		RemoteTarget: contacts[0].URI,
+		RouteSet:     routes,
+		LocalSeq:     1,
+		RemoteSeq:    cseq.Seq,
+	}, nil
+}
+
+// dialogKey key of the dialog in Softphone.calls
+func dialogKey(callID, localTag, remoteTag string) string {
+	return fmt.Sprintf("%s %s %s", callID, localTag, remoteTag)
+}
+
+// Call outgoing or incoming call with its dialog and PeerConnection
+type Call struct {
+	s              *Softphone
+	outgoing       bool
+	peerConnection *webrtc.PeerConnection
+	audioTrack     *AudioTrack
+
+	mu          sync.Mutex
+	dialog      Dialog
+	established bool
+	ended       bool
+	done        chan struct{}
+	// offering offer-answer exchange in progress, RFC 3261 14
+	offering   bool
+	localHold  bool
+	remoteHold bool
+	// session timer, RFC 4028
+	sessionInterval uint32
+	refresher       bool
+	sessionTimer    *time.Timer
+	// sessionDeadline when the session expires if it isn't refreshed
+	sessionDeadline    time.Time
+	remoteAllowsUpdate bool
+
+	// state of outgoing call before the answer
+	answered   chan struct{}
+	dialErr    error
+	invite     SipMessage
+	proceeding bool
+	canceling  bool
+	ack        *SipMessage
+	// earlySDP SDP of provisional response applied as the answer
+	earlySDP string
+	// earlyTag To tag of the early dialog which sent earlySDP
+	earlyTag string
+	// rseq RSeq of the last reliable provisional response
+	rseq uint32
+	// progress gets provisional responses besides OnProgress
+	progress func(response SipMessage)
+	// transfer REFER sent by us waiting for the result
+	transfer chan SipMessage
+}
+
+func newCall(s *Softphone, dialog Dialog, outgoing bool, peerConnection *webrtc.PeerConnection, audioTrack *AudioTrack) *Call {
+	return &Call{
+		s:              s,
+		outgoing:       outgoing,
+		peerConnection: peerConnection,
+		audioTrack:     audioTrack,
+		dialog:         dialog,
+		done:           make(chan struct{}),
+		answered:       make(chan struct{}),
+	}
+}
+
+// Dialog copy of the dialog state
+func (c *Call) Dialog() Dialog {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dialog
+}
+
+// Outgoing reports whether the call was made by Invite
+func (c *Call) Outgoing() bool {
+	return c.outgoing
+}
+
+// PeerConnection media of the call, outgoing call gets a new one when it is redirected after early media
+func (c *Call) PeerConnection() *webrtc.PeerConnection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.peerConnection
+}
+
+// AudioTrack local audio of the call
+func (c *Call) AudioTrack() *AudioTrack {
+	return c.audioTrack
+}
+
+// SendDTMF send digits in the established call by Options.DTMFMode: RFC 4733 telephone-events in the audio
+// or INFO requests. Each digit lasts duration, DefaultDTMFDuration if 0. Returns when all digits are sent.
+func (c *Call) SendDTMF(digits string, duration time.Duration) error {
+	c.mu.Lock()
+	established := c.established
+	c.mu.Unlock()
+	if !established {
+		return ErrCallNotEstablished
+	}
+	if c.s.options.DTMFMode != DTMFModeRFC4733 {
+		return c.sendInfoDTMF(digits, duration)
+	}
+	// RTP is sent when DTLS is connected, the track isn't bound without negotiated audio
+	select {
+	case <-c.audioTrack.bound:
+	case <-c.done:
+		return ErrCallNotEstablished
+	}
+	return c.audioTrack.SendDTMF(digits, duration)
+}
+
+// Done is closed when the call is ended
+func (c *Call) Done() <-chan struct{} {
+	return c.done
+}
+
+// Hangup end the established call: send BYE and close the PeerConnection
+func (c *Call) Hangup() error {
+	c.mu.Lock()
+	if !c.established {
+		c.mu.Unlock()
+		return ErrCallNotEstablished
+	}
+	request := c.newRequest("BYE")
+	c.mu.Unlock()
+
+	response, err := c.requestWithAuth(request)
+	// any response to BYE terminates the dialog, RFC 3261 15.1.1
+	c.terminate(HangupReason{Method: "BYE"})
+	if err != nil {
+		return err
+	}
+	if response.StatusCode() >= 300 {
+		return newResponseError(response)
+	}
+	return nil
+}
+
+// requestWithAuth send request inside the dialog and wait for the final response,
+// CSeq increased by authorization becomes the local CSeq of the dialog
+func (c *Call) requestWithAuth(request SipMessage) (SipMessage, error) {
+	request, response, err := c.s.requestWithAuth(request)
+	if cseq, cseqErr := request.CSeq(); cseqErr == nil {
+		c.mu.Lock()
+		if cseq.Seq > c.dialog.LocalSeq {
+			c.dialog.LocalSeq = cseq.Seq
+		}
+		c.mu.Unlock()
+	}
+	return response, err
+}
+
+// updateFromResponse take remote tag, target and route set from the dialog creating response to INVITE sent by us.
+// c.mu must be held.
+func (c *Call) updateFromResponse(response SipMessage) error {
+	to, err := response.To()
+	if err != nil {
+		return err
+	}
+	c.dialog.RemoteTag = to.Tag()
+	contacts, err := response.Contacts()
+	if err != nil {
+		return err
+	}
+	if len(contacts) > 0 {
+		c.dialog.RemoteTarget = contacts[0].URI
+	}
+	routes, err := response.RecordRoutes()
+	if err != nil {
+		return err
+	}
+	c.dialog.RouteSet = []NameAddr{}
+	for i := len(routes) - 1; i >= 0; i-- {
+		c.dialog.RouteSet = append(c.dialog.RouteSet, routes[i])
+	}
+	return nil
+}
+
+// confirm the dialog is established, the call can be found by in-dialog requests
+func (c *Call) confirm() {
+	c.mu.Lock()
+	if c.ended {
+		c.mu.Unlock()
+		return
+	}
+	c.established = true
+	key := dialogKey(c.dialog.CallID, c.dialog.LocalTag, c.dialog.RemoteTag)
+	c.mu.Unlock()
+
+	c.s.mu.Lock()
+	c.s.calls[key] = c
+	c.s.mu.Unlock()
+}
+
+// terminate end the call and report it to OnHangup
+func (c *Call) terminate(reason HangupReason) {
+	if c.close() && c.s.OnHangup != nil {
+		c.s.OnHangup(c, reason)
+	}
+}
+
+// close forget the dialog and close the PeerConnection, returns false if the call is already ended
+func (c *Call) close() bool {
+	c.mu.Lock()
+	if c.ended {
+		c.mu.Unlock()
+		return false
+	}
+	c.ended = true
+	c.established = false
+	c.stopSessionTimer()
+	key := dialogKey(c.dialog.CallID, c.dialog.LocalTag, c.dialog.RemoteTag)
+	peerConnection := c.peerConnection
+	c.mu.Unlock()
+
+	c.s.mu.Lock()
+	if c.s.calls[key] == c {
+		delete(c.s.calls, key)
+	}
+	c.s.mu.Unlock()
+
+	if peerConnection != nil {
+		if err := peerConnection.Close(); err != nil {
+			log.Println(err)
+		}
+	}
+	close(c.done)
+	return true
+}
+
+// newRequest request inside the dialog of the call. c.mu must be held.
+func (c *Call) newRequest(method string) SipMessage {
+	return c.s.dialogRequest(&c.dialog, method)
+}
+
+// dialogRequest request inside the dialog, CSeq is increased except for ACK and CANCEL
+func (s *Softphone) dialogRequest(dialog *Dialog, method string) SipMessage {
+	if method != "ACK" && method != "CANCEL" {
+		dialog.LocalSeq++
+	}
+	from := dialog.LocalURI
+	from.Params = Params{{"tag", dialog.LocalTag}}
+	to := dialog.RemoteURI
+	to.Params = Params{}
+	if dialog.RemoteTag != "" {
+		to.Params = Params{{"tag", dialog.RemoteTag}}
+	}
+
+	request := SipMessage{
+		Subject: fmt.Sprintf("%s %s SIP/2.0", method, dialog.RemoteTarget),
+		Headers: Headers{
+			{"Via", s.via().String()},
+			{"Max-Forwards", "70"},
+			{"From", from.String()},
+			{"To", to.String()},
+			{"Call-ID", dialog.CallID},
+			{"CSeq", CSeq{Seq: dialog.LocalSeq, Method: method}.String()},
+		},
+	}
+	for _, route := range dialog.RouteSet {
+		request.Headers.Add("Route", route.String())
+	}
+	if method != "CANCEL" {
+		request.Headers.Add("Contact", s.contact().String())
+	}
+	return request
+}
+
+// findCall call of the dialog of request received by us
+func (s *Softphone) findCall(request SipMessage) *Call {
+	from, err := request.From()
+	if err != nil {
+		return nil
+	}
+	to, err := request.To()
+	if err != nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[dialogKey(request.Headers.Get("Call-ID"), to.Tag(), from.Tag())]
+}
+
+// handleBye answer BYE and end its call
+func (s *Softphone) handleBye(t *ServerTransaction) error {
+	call := s.findCall(t.Request())
+	if call == nil {
+		return t.Reply(481, "Call/Transaction Does Not Exist")
+	}
+	err := t.Reply(200, "OK")
+	go call.terminate(HangupReason{
+		Remote: true,
+		Method: "BYE",
+		Reason: strings.Join(t.Request().Headers.Values("Reason"), ", "),
+	})
+	return err
+}
+
+// handleCancel answer CANCEL, reject its INVITE with 487 and end the call being answered
+func (s *Softphone) handleCancel(t *ServerTransaction) error {
+	via, err := t.Request().Via()
+	if err != nil {
+		return t.Reply(400, "Bad Request")
+	}
+	s.mu.Lock()
+	invite, ok := s.serverTransactions[via.Branch()+" INVITE"]
+	s.mu.Unlock()
+	if !ok {
+		return t.Reply(481, "Call/Transaction Does Not Exist")
+	}
+	// 200 to CANCEL and 487 to INVITE carry the To tag of the early dialog, RFC 3261 9.2
+	t.mu.Lock()
+	t.tag = invite.localTag()
+	t.mu.Unlock()
+	if err := t.Reply(200, "OK"); err != nil {
+		return err
+	}
+	if invite.Answered() {
+		return nil
+	}
+	if err := invite.Reply(487, "Request Terminated"); err != nil && err != ErrAlreadyAnswered {
+		return err
+	}
+	invite.mu.Lock()
+	call := invite.call
+	invite.mu.Unlock()
+	if call != nil {
+		go call.terminate(HangupReason{
+			Remote: true,
+			Method: "CANCEL",
+			Reason: strings.Join(t.Request().Headers.Values("Reason"), ", "),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package softphone
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// DialogInfo dialog state document of "dialog" event package, application/dialog-info+xml, RFC 4235.
+// Partial documents contain only the changed dialogs.
+type DialogInfo struct {
+	Version uint32 `xml:"version,attr"`
+	// State "full" or "partial"
+	State   string           `xml:"state,attr"`
+	Entity  string           `xml:"entity,attr"`
+	Dialogs []DialogInfoItem `xml:"dialog"`
+}
+
+// DialogInfoItem one dialog of the observed user
+type DialogInfoItem struct {
+	ID        string `xml:"id,attr"`
+	CallID    string `xml:"call-id,attr"`
+	LocalTag  string `xml:"local-tag,attr"`
+	RemoteTag string `xml:"remote-tag,attr"`
+	// Direction "initiator" or "recipient"
+	Direction string          `xml:"direction,attr"`
+	State     DialogInfoState `xml:"state"`
+	Duration  uint32          `xml:"duration"`
+	// LocalIdentity and RemoteIdentity URIs of the observed user and the other party
+	LocalIdentity  string `xml:"local>identity"`
+	RemoteIdentity string `xml:"remote>identity"`
+}
+
+// DialogInfoState state of the dialog with the event which led to it
+type DialogInfoState struct {
+	// Value "trying", "proceeding", "early", "confirmed" or "terminated"
+	Value string `xml:",chardata"`
+	// Event "cancelled", "rejected", "replaced", "local-bye", "remote-bye", "error" or "timeout"
+	Event string `xml:"event,attr"`
+	Code  int    `xml:"code,attr"`
+}
+
+// ParseDialogInfo parse dialog-info document
+func ParseDialogInfo(body string) (DialogInfo, error) {
+	var info DialogInfo
+	if err := xml.Unmarshal([]byte(body), &info); err != nil {
+		return DialogInfo{}, &ParseError{Reason: "invalid dialog-info: " + err.Error()}
+	}
+	for i := range info.Dialogs {
+		info.Dialogs[i].State.Value = strings.TrimSpace(info.Dialogs[i].State.Value)
+	}
+	return info, nil
+}
+
+// Ringing reports whether the observed user has an early dialog, BLF lamp blinks
+func (info DialogInfo) Ringing() bool {
+	for _, dialog := range info.Dialogs {
+		if dialog.State.Value == "early" {
+			return true
+		}
+	}
+	return false
+}
+
+// Busy reports whether the observed user has a confirmed dialog, BLF lamp is on
+func (info DialogInfo) Busy() bool {
+	for _, dialog := range info.Dialogs {
+		if dialog.State.Value == "confirmed" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package softphone
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDialogInfo(t *testing.T) {
+	// RFC 4235 5.2, dialog of the observed user who is called
+	body := `<?xml version="1.0"?>
+<dialog-info xmlns="urn:ietf:params:xml:ns:dialog-info" version="1" state="full" entity="sip:alice@example.com">
+  <dialog id="as7d900as8" call-id="a84b4c76e66710" local-tag="1928301774" remote-tag="456887766" direction="recipient">
+    <state event="rejected" code="486"> terminated </state>
+    <duration>274</duration>
+    <local><identity display="Alice">sip:alice@example.com</identity></local>
+    <remote><identity>sip:bob@example.org</identity></remote>
+  </dialog>
+  <dialog id="123" direction="initiator">
+    <state>early</state>
+  </dialog>
+</dialog-info>`
+	info, err := ParseDialogInfo(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := DialogInfo{
+		Version: 1,
+		State:   "full",
+		Entity:  "sip:alice@example.com",
+		Dialogs: []DialogInfoItem{
+			{
+				ID:             "as7d900as8",
+				CallID:         "a84b4c76e66710",
+				LocalTag:       "1928301774",
+				RemoteTag:      "456887766",
+				Direction:      "recipient",
+				State:          DialogInfoState{Value: "terminated", Event: "rejected", Code: 486},
+				Duration:       274,
+				LocalIdentity:  "sip:alice@example.com",
+				RemoteIdentity: "sip:bob@example.org",
+			},
+			{ID: "123", Direction: "initiator", State: DialogInfoState{Value: "early"}},
+		},
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("dialog-info\n%+v\nexpected\n%+v", info, expected)
+	}
+	if !info.Ringing() || info.Busy() {
+		t.Errorf("ringing %t busy %t", info.Ringing(), info.Busy())
+	}
+}
+
+func TestDialogInfoBusy(t *testing.T) {
+	body := `<dialog-info xmlns="urn:ietf:params:xml:ns:dialog-info" version="2" state="partial" entity="sip:alice@example.com">
+  <dialog id="1"><state>confirmed</state></dialog>
+</dialog-info>`
+	info, err := ParseDialogInfo(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State != "partial" || info.Ringing() || !info.Busy() {
+		t.Errorf("state %q ringing %t busy %t", info.State, info.Ringing(), info.Busy())
+	}
+
+	info, err = ParseDialogInfo(`<dialog-info version="3" state="full" entity="sip:alice@example.com"/>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Ringing() || info.Busy() {
+		t.Errorf("idle user is ringing %t busy %t", info.Ringing(), info.Busy())
+	}
+}
+
+func TestParseDialogInfoInvalid(t *testing.T) {
+	for _, body := range []string{"", "dialog-info", `<dialog-info version="x"/>`, `<dialog-info><dialog>`} {
+		if info, err := ParseDialogInfo(body); err == nil {
+			t.Errorf("%q parsed as %+v", body, info)
+		} else if _, ok := err.(*ParseError); !ok {
+			t.Errorf("%q error %v isn't ParseError", body, err)
+		}
+	}
+}
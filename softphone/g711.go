@@ -0,0 +1,139 @@
+package softphone
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v2"
+	"github.com/pion/webrtc/v3"
+)
+
+// ErrNoDecoder there is no built-in decoder of the codec
+var ErrNoDecoder = errors.New("no decoder of the codec")
+
+// Decoder decoder of RTP payload of audio to 16 bit linear PCM
+type Decoder interface {
+	// Decode decode payload of one RTP packet
+	Decode(payload []byte) ([]int16, error)
+	// SampleRate sample rate of decoded audio
+	SampleRate() int
+}
+
+// g711Codecs PCMU and PCMA with static payload types, RFC 3551
+var g711Codecs = []webrtc.RTPCodecParameters{
+	{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000}, PayloadType: 0},
+	{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMA, ClockRate: 8000}, PayloadType: 8},
+}
+
+// registerG711 register PCMU and PCMA, codecs registered first are listed first in offers
+func registerG711(mediaEngine *webrtc.MediaEngine) {
+	for _, codec := range g711Codecs {
+		if err := mediaEngine.RegisterCodec(codec, webrtc.RTPCodecTypeAudio); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// preferG711 move PCMU and PCMA to the front of the audio formats of the offer, the answer lists them first,
+// so the other side sends audio which NewDecoder can decode. The order of other formats is kept.
+func preferG711(offer string) string {
+	parsed := &sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(offer)); err != nil {
+		// SetRemoteDescription reports invalid SDP
+		return offer
+	}
+	for _, media := range parsed.MediaDescriptions {
+		if media.MediaName.Media != "audio" {
+			continue
+		}
+		formats := []string{}
+		other := []string{}
+		for _, format := range media.MediaName.Formats {
+			if isG711Format(parsed, format) {
+				formats = append(formats, format)
+			} else {
+				other = append(other, format)
+			}
+		}
+		media.MediaName.Formats = append(formats, other...)
+	}
+	out, err := parsed.Marshal()
+	if err != nil {
+		return offer
+	}
+	return string(out)
+}
+
+// isG711Format reports whether payload type format of SDP is PCMU or PCMA, static 0 and 8 may have no rtpmap
+func isG711Format(parsed *sdp.SessionDescription, format string) bool {
+	if format == "0" || format == "8" {
+		return true
+	}
+	payloadType, err := strconv.ParseUint(format, 10, 8)
+	if err != nil {
+		return false
+	}
+	codec, err := parsed.GetCodecForPayloadType(uint8(payloadType))
+	return err == nil && (strings.EqualFold(codec.Name, "PCMU") || strings.EqualFold(codec.Name, "PCMA"))
+}
+
+// NewDecoder decoder of G.711 audio, PCMU or PCMA. Opus needs an external Decoder, for example based on libopus.
+func NewDecoder(codec webrtc.RTPCodecParameters) (Decoder, error) {
+	switch {
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypePCMU):
+		return g711Decoder{decode: decodeULaw}, nil
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypePCMA):
+		return g711Decoder{decode: decodeALaw}, nil
+	}
+	return nil, ErrNoDecoder
+}
+
+// g711Decoder decoder of G.711, one byte per sample at 8000 Hz
+type g711Decoder struct {
+	decode func(b byte) int16
+}
+
+// Decode decode each byte of the payload
+func (d g711Decoder) Decode(payload []byte) ([]int16, error) {
+	samples := make([]int16, len(payload))
+	for i, b := range payload {
+		samples[i] = d.decode(b)
+	}
+	return samples, nil
+}
+
+// SampleRate 8000 Hz
+func (d g711Decoder) SampleRate() int {
+	return 8000
+}
+
+// decodeULaw decode μ-law sample, ITU-T G.711
+func decodeULaw(b byte) int16 {
+	u := ^b
+	t := (int16(u&0x0f) << 3) + 0x84
+	t <<= (u & 0x70) >> 4
+	if u&0x80 != 0 {
+		return 0x84 - t
+	}
+	return t - 0x84
+}
+
+// decodeALaw decode A-law sample, ITU-T G.711
+func decodeALaw(b byte) int16 {
+	a := b ^ 0x55
+	t := int16(a&0x0f) << 4
+	switch segment := (a & 0x70) >> 4; segment {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= segment - 1
+	}
+	if a&0x80 != 0 {
+		return t
+	}
+	return -t
+}
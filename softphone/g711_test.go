@@ -0,0 +1,30 @@
+package softphone
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPreferG711(t *testing.T) {
+	offer := "v=0\r\n" +
+		"o=- 1 1 IN IP4 192.0.2.1\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 192.0.2.1\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 4000 RTP/AVP 111 9 8 96 101 0\r\n" +
+		"a=rtpmap:111 opus/48000/2\r\n" +
+		"a=rtpmap:9 G722/8000\r\n" +
+		"a=rtpmap:96 PCMU/8000\r\n" +
+		"a=rtpmap:101 telephone-event/8000\r\n" +
+		"m=video 4002 RTP/AVP 0\r\n"
+	preferred := preferG711(offer)
+	for _, expected := range []string{"\r\nm=audio 4000 RTP/AVP 8 96 0 111 9 101\r\n", "\r\nm=video 4002 RTP/AVP 0\r\n"} {
+		if !strings.Contains(preferred, expected) {
+			t.Errorf("%q not in\n%s", expected, preferred)
+		}
+	}
+
+	if invalid := "m=audio"; preferG711(invalid) != invalid {
+		t.Errorf("invalid SDP is changed to %q", preferG711(invalid))
+	}
+}
@@ -0,0 +1,446 @@
+package softphone
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// NameAddr name-addr or addr-spec of From, To, Contact, Route and similar headers
+type NameAddr struct {
+	DisplayName string
+	URI         URI
+	Params      Params
+}
+
+// ParseNameAddr parse `"Display Name" <sip:user@host>;tag=1` or `sip:user@host;tag=1`
+func ParseNameAddr(s string) (NameAddr, error) {
+	s = strings.TrimSpace(s)
+	nameAddr := NameAddr{}
+	rest := s
+	if strings.HasPrefix(s, "\"") {
+		displayName, n, err := unquote(s)
+		if err != nil {
+			return NameAddr{}, err
+		}
+		nameAddr.DisplayName = displayName
+		rest = strings.TrimSpace(s[n:])
+		if !strings.HasPrefix(rest, "<") {
+			return NameAddr{}, &ParseError{Reason: fmt.Sprintf("no URI after display name in %q", s)}
+		}
+	}
+
+	var params []string
+	if lt := strings.IndexByte(rest, '<'); lt >= 0 {
+		gt := strings.IndexByte(rest, '>')
+		if gt < lt {
+			return NameAddr{}, &ParseError{Reason: fmt.Sprintf("unbalanced angle brackets in %q", s)}
+		}
+		if nameAddr.DisplayName == "" {
+			nameAddr.DisplayName = strings.TrimSpace(rest[:lt])
+		}
+		uri, err := ParseURI(rest[lt+1 : gt])
+		if err != nil {
+			return NameAddr{}, err
+		}
+		nameAddr.URI = uri
+		rest = strings.TrimSpace(rest[gt+1:])
+		if rest != "" && rest[0] != ';' {
+			return NameAddr{}, &ParseError{Reason: fmt.Sprintf("unexpected %q after URI", rest)}
+		}
+		params = splitQuoted(rest, ';')
+	} else {
+		// addr-spec, parameters after the URI belong to the header
+		parts := splitQuoted(rest, ';')
+		uri, err := ParseURI(parts[0])
+		if err != nil {
+			return NameAddr{}, err
+		}
+		nameAddr.URI = uri
+		params = parts[1:]
+	}
+	p, err := parseParams(params)
+	if err != nil {
+		return NameAddr{}, err
+	}
+	nameAddr.Params = p
+	return nameAddr, nil
+}
+
+// String name-addr form `"Display Name" <sip:user@host>;tag=1`
+func (nameAddr NameAddr) String() string {
+	res := ""
+	if nameAddr.DisplayName != "" {
+		res = quote(nameAddr.DisplayName) + " "
+	}
+	return res + "<" + nameAddr.URI.String() + ">" + nameAddr.Params.String()
+}
+
+// Tag value of tag parameter
+func (nameAddr NameAddr) Tag() string {
+	tag, _ := nameAddr.Params.Get("tag")
+	return tag
+}
+
+// ParseNameAddrs parse comma separated list of name-addr, as in Contact or Record-Route
+func ParseNameAddrs(s string) ([]NameAddr, error) {
+	res := []NameAddr{}
+	for _, value := range splitQuoted(s, ',') {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		nameAddr, err := ParseNameAddr(value)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, nameAddr)
+	}
+	return res, nil
+}
+
+// Via value of Via header
+type Via struct {
+	// Transport UDP, TCP, WS, WSS
+	Transport string
+	Host      string
+	// Port 0 if sent-by has no port
+	Port   int
+	Params Params
+}
+
+// ParseVia parse one via-parm "SIP/2.0/WSS host:port;branch=z9hG4bK1"
+func ParseVia(s string) (Via, error) {
+	parts := splitQuoted(strings.TrimSpace(s), ';')
+	protocol := strings.SplitN(parts[0], "/", 3)
+	if len(protocol) != 3 || !strings.EqualFold(strings.TrimSpace(protocol[0]), "SIP") || strings.TrimSpace(protocol[1]) != "2.0" {
+		return Via{}, &ParseError{Reason: fmt.Sprintf("invalid Via %q", s)}
+	}
+	fields := strings.Fields(protocol[2])
+	if len(fields) < 2 {
+		return Via{}, &ParseError{Reason: fmt.Sprintf("invalid Via %q", s)}
+	}
+	host, port, err := parseHostPort(strings.Join(fields[1:], ""))
+	if err != nil {
+		return Via{}, err
+	}
+	params, err := parseParams(parts[1:])
+	if err != nil {
+		return Via{}, err
+	}
+	return Via{
+		Transport: strings.ToUpper(fields[0]),
+		Host:      host,
+		Port:      port,
+		Params:    params,
+	}, nil
+}
+
+// ParseVias parse comma separated list of via-parm
+func ParseVias(s string) ([]Via, error) {
+	res := []Via{}
+	for _, value := range splitQuoted(s, ',') {
+		via, err := ParseVia(value)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, via)
+	}
+	return res, nil
+}
+
+// String "SIP/2.0/WSS host:port;params"
+func (via Via) String() string {
+	host := via.Host
+	if via.Port != 0 {
+		host = fmt.Sprintf("%s:%d", via.Host, via.Port)
+	}
+	return fmt.Sprintf("SIP/2.0/%s %s%s", via.Transport, host, via.Params.String())
+}
+
+// Branch value of branch parameter
+func (via Via) Branch() string {
+	branch, _ := via.Params.Get("branch")
+	return branch
+}
+
+// Received value of received parameter
+func (via Via) Received() string {
+	received, _ := via.Params.Get("received")
+	return received
+}
+
+// RPort value of rport parameter, 0 if it is absent or has no value
+func (via Via) RPort() int {
+	value, _ := via.Params.Get("rport")
+	port, _ := strconv.Atoi(value)
+	return port
+}
+
+// newBranch new RFC 3261 branch with magic cookie
+func newBranch() string {
+	return "z9hG4bK" + uuid.New().String()
+}
+
+// CSeq value of CSeq header
+type CSeq struct {
+	Seq    uint32
+	Method string
+}
+
+// ParseCSeq parse "8083 INVITE"
+func ParseCSeq(s string) (CSeq, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return CSeq{}, &ParseError{Reason: fmt.Sprintf("invalid CSeq %q", s)}
+	}
+	seq, err := strconv.ParseUint(fields[0], 10, 31)
+	if err != nil {
+		return CSeq{}, &ParseError{Reason: fmt.Sprintf("invalid CSeq number %q", fields[0])}
+	}
+	return CSeq{Seq: uint32(seq), Method: fields[1]}, nil
+}
+
+// String "8083 INVITE"
+func (cseq CSeq) String() string {
+	return fmt.Sprintf("%d %s", cseq.Seq, cseq.Method)
+}
+
+// RAck value of RAck header, RFC 3262 7.2
+type RAck struct {
+	RSeq   uint32
+	CSeq   uint32
+	Method string
+}
+
+// ParseRAck parse "776656 1 INVITE"
+func ParseRAck(s string) (RAck, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 3 {
+		return RAck{}, &ParseError{Reason: fmt.Sprintf("invalid RAck %q", s)}
+	}
+	rseq, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return RAck{}, &ParseError{Reason: fmt.Sprintf("invalid RAck number %q", fields[0])}
+	}
+	cseq, err := ParseCSeq(fields[1] + " " + fields[2])
+	if err != nil {
+		return RAck{}, err
+	}
+	return RAck{RSeq: uint32(rseq), CSeq: cseq.Seq, Method: cseq.Method}, nil
+}
+
+// String "776656 1 INVITE"
+func (rack RAck) String() string {
+	return fmt.Sprintf("%d %d %s", rack.RSeq, rack.CSeq, rack.Method)
+}
+
+// SessionExpires value of Session-Expires header, RFC 4028 4
+type SessionExpires struct {
+	Delta uint32
+	// Refresher "uac", "uas" or empty
+	Refresher string
+}
+
+// ParseSessionExpires parse "1800;refresher=uac"
+func ParseSessionExpires(s string) (SessionExpires, error) {
+	parts := strings.Split(s, ";")
+	delta, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 32)
+	if err != nil {
+		return SessionExpires{}, &ParseError{Reason: fmt.Sprintf("invalid Session-Expires %q", s)}
+	}
+	params, err := parseParams(parts[1:])
+	if err != nil {
+		return SessionExpires{}, err
+	}
+	refresher, _ := params.Get("refresher")
+	return SessionExpires{Delta: uint32(delta), Refresher: strings.ToLower(refresher)}, nil
+}
+
+// String "1800;refresher=uac"
+func (sessionExpires SessionExpires) String() string {
+	if sessionExpires.Refresher == "" {
+		return strconv.FormatUint(uint64(sessionExpires.Delta), 10)
+	}
+	return fmt.Sprintf("%d;refresher=%s", sessionExpires.Delta, sessionExpires.Refresher)
+}
+
+// Reason value of Reason header, RFC 3326
+type Reason struct {
+	// Protocol "SIP" or "Q.850"
+	Protocol string
+	Cause    int
+	Text     string
+}
+
+// ParseReasons parse `Q.850;cause=16;text="NORMAL_CLEARING", SIP;cause=200`
+func ParseReasons(s string) ([]Reason, error) {
+	res := []Reason{}
+	for _, value := range splitQuoted(s, ',') {
+		parts := splitQuoted(value, ';')
+		protocol := strings.TrimSpace(parts[0])
+		if !isToken(protocol) {
+			return nil, &ParseError{Reason: fmt.Sprintf("invalid Reason %q", value)}
+		}
+		params, err := parseParams(parts[1:])
+		if err != nil {
+			return nil, err
+		}
+		reason := Reason{Protocol: protocol}
+		if cause, ok := params.Get("cause"); ok {
+			if reason.Cause, err = strconv.Atoi(cause); err != nil {
+				return nil, &ParseError{Reason: fmt.Sprintf("invalid Reason cause %q", cause)}
+			}
+		}
+		if text, ok := params.Get("text"); ok {
+			reason.Text = text
+			if strings.HasPrefix(text, `"`) {
+				if reason.Text, _, err = unquote(text); err != nil {
+					return nil, err
+				}
+			}
+		}
+		res = append(res, reason)
+	}
+	return res, nil
+}
+
+// String `Q.850;cause=16;text="NORMAL_CLEARING"`
+func (reason Reason) String() string {
+	params := Params{{"cause", strconv.Itoa(reason.Cause)}}
+	if reason.Text != "" {
+		params = append(params, Param{"text", quote(reason.Text)})
+	}
+	return reason.Protocol + params.String()
+}
+
+// Replaces value of Replaces header, the dialog to be replaced as seen by the recipient of INVITE, RFC 3891 6.1
+type Replaces struct {
+	CallID  string
+	ToTag   string
+	FromTag string
+	// EarlyOnly only an early dialog can be replaced
+	EarlyOnly bool
+}
+
+// ParseReplaces parse "425928@bobster.example.org;to-tag=7743;from-tag=6472"
+func ParseReplaces(s string) (Replaces, error) {
+	parts := strings.Split(s, ";")
+	callID := strings.TrimSpace(parts[0])
+	if callID == "" {
+		return Replaces{}, &ParseError{Reason: fmt.Sprintf("invalid Replaces %q", s)}
+	}
+	params, err := parseParams(parts[1:])
+	if err != nil {
+		return Replaces{}, err
+	}
+	toTag, hasTo := params.Get("to-tag")
+	fromTag, hasFrom := params.Get("from-tag")
+	if !hasTo || !hasFrom {
+		return Replaces{}, &ParseError{Reason: fmt.Sprintf("Replaces without tags %q", s)}
+	}
+	_, earlyOnly := params.Get("early-only")
+	return Replaces{CallID: callID, ToTag: toTag, FromTag: fromTag, EarlyOnly: earlyOnly}, nil
+}
+
+// String "425928@bobster.example.org;to-tag=7743;from-tag=6472"
+func (replaces Replaces) String() string {
+	params := Params{{"to-tag", replaces.ToTag}, {"from-tag", replaces.FromTag}}
+	if replaces.EarlyOnly {
+		params = append(params, Param{Name: "early-only"})
+	}
+	return replaces.CallID + params.String()
+}
+
+// SubscriptionState value of Subscription-State header, RFC 6665 8.2.3
+type SubscriptionState struct {
+	// State "active", "pending" or "terminated"
+	State string
+	// Expires 0 if the header has no expires
+	Expires uint32
+	Reason  string
+}
+
+// ParseSubscriptionState parse "active;expires=3600" or "terminated;reason=timeout"
+func ParseSubscriptionState(s string) (SubscriptionState, error) {
+	parts := strings.Split(s, ";")
+	state := strings.ToLower(strings.TrimSpace(parts[0]))
+	if !isToken(state) {
+		return SubscriptionState{}, &ParseError{Reason: fmt.Sprintf("invalid Subscription-State %q", s)}
+	}
+	params, err := parseParams(parts[1:])
+	if err != nil {
+		return SubscriptionState{}, err
+	}
+	res := SubscriptionState{State: state}
+	if expires, ok := params.Get("expires"); ok {
+		delta, err := strconv.ParseUint(expires, 10, 32)
+		if err != nil {
+			return SubscriptionState{}, &ParseError{Reason: fmt.Sprintf("invalid Subscription-State expires %q", expires)}
+		}
+		res.Expires = uint32(delta)
+	}
+	res.Reason, _ = params.Get("reason")
+	return res, nil
+}
+
+// String "active;expires=3600"
+func (state SubscriptionState) String() string {
+	params := Params{}
+	if state.Expires > 0 {
+		params = append(params, Param{"expires", strconv.FormatUint(uint64(state.Expires), 10)})
+	}
+	if state.Reason != "" {
+		params = append(params, Param{"reason", state.Reason})
+	}
+	return state.State + params.String()
+}
+
+// splitQuoted split s by sep outside of quoted strings and angle brackets
+func splitQuoted(s string, sep byte) []string {
+	res := []string{}
+	quoted := false
+	angle := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == '<':
+			angle = true
+		case !quoted && c == '>':
+			angle = false
+		case !quoted && !angle && c == sep:
+			res = append(res, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(res, s[start:])
+}
+
+// unquote unquote quoted-string at the beginning of s, returns the value and length of quoted-string
+func unquote(s string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+			if i < len(s) {
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", 0, &ParseError{Reason: fmt.Sprintf("unbalanced quotes in %q", s)}
+}
+
+// quote quoted-string of s
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
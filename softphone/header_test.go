@@ -0,0 +1,67 @@
+package softphone
+
+import "testing"
+
+func TestNameAddrRoundTrip(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{`"Alice \"A\"" <sip:alice@example.com>;tag=1928301774`, `"Alice \"A\"" <sip:alice@example.com>;tag=1928301774`},
+		{`<sip:bob@example.com;transport=ws>;expires=200`, `<sip:bob@example.com;transport=ws>;expires=200`},
+		{`Bob <sip:bob@example.com>`, `"Bob" <sip:bob@example.com>`},
+		// parameters of addr-spec belong to the header
+		{`sip:carol@example.com;tag=x`, `<sip:carol@example.com>;tag=x`},
+	}
+	for _, test := range tests {
+		nameAddr, err := ParseNameAddr(test.value)
+		if err != nil {
+			t.Errorf("%s: %v", test.value, err)
+			continue
+		}
+		if s := nameAddr.String(); s != test.expected {
+			t.Errorf("%s: string %s, expected %s", test.value, s, test.expected)
+		}
+		again, err := ParseNameAddr(nameAddr.String())
+		if err != nil || again.String() != nameAddr.String() {
+			t.Errorf("%s: parsed again %s %v", test.value, again, err)
+		}
+	}
+}
+
+func TestViaRoundTrip(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"SIP/2.0/WSS client.invalid;branch=z9hG4bK776asdhds", "SIP/2.0/WSS client.invalid;branch=z9hG4bK776asdhds"},
+		{"SIP/2.0/UDP 192.0.2.1:5060;received=192.0.2.2;rport=5061", "SIP/2.0/UDP 192.0.2.1:5060;received=192.0.2.2;rport=5061"},
+		{"SIP / 2.0 / tcp [2001:db8::1] ; branch = z9hG4bK1", "SIP/2.0/TCP [2001:db8::1];branch=z9hG4bK1"},
+	}
+	for _, test := range tests {
+		via, err := ParseVia(test.value)
+		if err != nil {
+			t.Errorf("%s: %v", test.value, err)
+			continue
+		}
+		if s := via.String(); s != test.expected {
+			t.Errorf("%s: string %s, expected %s", test.value, s, test.expected)
+		}
+	}
+	vias, err := ParseVias("SIP/2.0/WSS a.invalid;branch=z9hG4bK1, SIP/2.0/UDP b.invalid:5060;branch=z9hG4bK2")
+	if err != nil || len(vias) != 2 || vias[1].Port != 5060 || vias[1].Branch() != "z9hG4bK2" {
+		t.Errorf("vias %v %v", vias, err)
+	}
+}
+
+func TestCSeqRoundTrip(t *testing.T) {
+	cseq, err := ParseCSeq(" 8083   INVITE ")
+	if err != nil || cseq != (CSeq{Seq: 8083, Method: "INVITE"}) || cseq.String() != "8083 INVITE" {
+		t.Errorf("CSeq %v %v", cseq, err)
+	}
+	for _, s := range []string{"INVITE", "1", "x INVITE", "2147483648 INVITE", "1 INVITE x"} {
+		if cseq, err := ParseCSeq(s); err == nil {
+			t.Errorf("%q parsed as %v", s, cseq)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package softphone
+
+import "github.com/pion/webrtc/v3"
+
+// Hold put the call on hold: re-INVITE with a=sendonly, or a=inactive if the other side holds the call too.
+// Both sides stop sending media.
+func (c *Call) Hold() error {
+	return c.setHold(true)
+}
+
+// Resume take the call off hold: re-INVITE with a=sendrecv
+func (c *Call) Resume() error {
+	return c.setHold(false)
+}
+
+func (c *Call) setHold(hold bool) error {
+	c.mu.Lock()
+	previous := c.localHold
+	c.localHold = hold
+	c.mu.Unlock()
+	if err := c.reinvite(); err != nil {
+		c.mu.Lock()
+		c.localHold = previous
+		c.mu.Unlock()
+		return err
+	}
+	c.holdMedia()
+	return nil
+}
+
+// holdMedia stop sending media while the call is on hold by either side, pion v3.0.31 has no SetDirection
+// and can't bind the track again after ReplaceTrack(nil)
+func (c *Call) holdMedia() {
+	c.mu.Lock()
+	held := c.localHold || c.remoteHold
+	c.mu.Unlock()
+	c.audioTrack.setHeld(held)
+}
+
+// LocalHold reports whether the call is put on hold by us
+func (c *Call) LocalHold() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.localHold
+}
+
+// RemoteHold reports whether the call is put on hold by the other side
+func (c *Call) RemoteHold() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.remoteHold
+}
+
+// localDirection direction of our offer, inactive when the other side holds the call too, RFC 3264 8.4.
+// c.mu must be held.
+func (c *Call) localDirection() webrtc.RTPTransceiverDirection {
+	switch {
+	case c.localHold && c.remoteHold:
+		return webrtc.RTPTransceiverDirectionInactive
+	case c.localHold:
+		return webrtc.RTPTransceiverDirectionSendonly
+	}
+	return webrtc.RTPTransceiverDirectionSendrecv
+}
+
+// answerDirection direction of the answer to the offer with remote direction, RFC 3264 6.1.
+// We don't receive media while we hold the call.
+func answerDirection(localHold bool, remote webrtc.RTPTransceiverDirection) webrtc.RTPTransceiverDirection {
+	send := remote == webrtc.RTPTransceiverDirectionSendrecv || remote == webrtc.RTPTransceiverDirectionRecvonly
+	receive := !localHold && (remote == webrtc.RTPTransceiverDirectionSendrecv || remote == webrtc.RTPTransceiverDirectionSendonly)
+	switch {
+	case send && receive:
+		return webrtc.RTPTransceiverDirectionSendrecv
+	case send:
+		return webrtc.RTPTransceiverDirectionSendonly
+	case receive:
+		return webrtc.RTPTransceiverDirectionRecvonly
+	}
+	return webrtc.RTPTransceiverDirectionInactive
+}
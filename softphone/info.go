@@ -0,0 +1,142 @@
+package softphone
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DTMFMode how DTMF digits are sent
+type DTMFMode int
+
+const (
+	// DTMFModeRFC4733 telephone-events in the audio RTP stream
+	DTMFModeRFC4733 DTMFMode = iota
+	// DTMFModeInfoRelay INFO with application/dtmf-relay body, "Signal=5" and "Duration=100"
+	DTMFModeInfoRelay
+	// DTMFModeInfo INFO with application/dtmf body, the digit
+	DTMFModeInfo
+)
+
+// sendInfoDTMF send each digit in INFO, the next digit is sent after the duration of the previous one
+func (c *Call) sendInfoDTMF(digits string, duration time.Duration) error {
+	for _, digit := range digits {
+		if _, err := dtmfEvent(digit); err != nil {
+			return err
+		}
+	}
+	if duration <= 0 {
+		duration = DefaultDTMFDuration
+	}
+
+	for i, digit := range strings.ToUpper(digits) {
+		if i > 0 {
+			time.Sleep(duration + dtmfGap)
+		}
+		c.mu.Lock()
+		if !c.established {
+			c.mu.Unlock()
+			return ErrCallNotEstablished
+		}
+		request := c.newRequest("INFO")
+		c.mu.Unlock()
+		if c.s.options.DTMFMode == DTMFModeInfo {
+			request.Headers.Add("Content-Type", "application/dtmf")
+			request.Body = string(digit)
+		} else {
+			request.Headers.Add("Content-Type", "application/dtmf-relay")
+			request.Body = fmt.Sprintf("Signal=%c\r\nDuration=%d\r\n", digit, duration.Milliseconds())
+		}
+		response, err := c.requestWithAuth(request)
+		if err != nil {
+			return err
+		}
+		if response.StatusCode() >= 300 {
+			return newResponseError(response)
+		}
+	}
+	return nil
+}
+
+// parseDTMFDigit parse digit or its event code, "5", "#" or "11"
+func parseDTMFDigit(s string) (rune, error) {
+	s = strings.TrimSpace(s)
+	if event, err := strconv.Atoi(s); err == nil && event >= 10 && event < len(dtmfDigits) {
+		return rune(dtmfDigits[event]), nil
+	}
+	if len(s) != 1 || !strings.ContainsRune(dtmfDigits, unicode.ToUpper(rune(s[0]))) {
+		return 0, &ParseError{Reason: fmt.Sprintf("invalid DTMF digit %q", s)}
+	}
+	return unicode.ToUpper(rune(s[0])), nil
+}
+
+// parseDTMFRelay parse application/dtmf-relay body, "Signal=5\r\nDuration=160"
+func parseDTMFRelay(body string) (rune, time.Duration, error) {
+	var digit rune
+	var duration time.Duration
+	hasSignal := false
+	for _, line := range strings.Split(body, "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.ToLower(strings.TrimSpace(parts[0])) {
+		case "signal":
+			var err error
+			if digit, err = parseDTMFDigit(value); err != nil {
+				return 0, 0, err
+			}
+			hasSignal = true
+		case "duration":
+			ms, err := strconv.Atoi(value)
+			if err != nil {
+				return 0, 0, &ParseError{Reason: fmt.Sprintf("invalid DTMF duration %q", value)}
+			}
+			duration = time.Duration(ms) * time.Millisecond
+		}
+	}
+	if !hasSignal {
+		return 0, 0, &ParseError{Reason: "dtmf-relay without Signal"}
+	}
+	return digit, duration, nil
+}
+
+// handleInfo answer INFO in a call, DTMF digit of the body goes to OnDTMF
+func (s *Softphone) handleInfo(t *ServerTransaction) error {
+	request := t.Request()
+	call := s.findCall(request)
+	if call == nil {
+		return t.Reply(481, "Call/Transaction Does Not Exist")
+	}
+	// INFO without body keeps the dialog alive
+	if strings.TrimSpace(request.Body) == "" {
+		return t.Reply(200, "OK")
+	}
+
+	var digit rune
+	var duration time.Duration
+	var err error
+	switch contentType := strings.ToLower(strings.TrimSpace(strings.SplitN(request.Headers.Get("Content-Type"), ";", 2)[0])); contentType {
+	case "application/dtmf-relay":
+		digit, duration, err = parseDTMFRelay(request.Body)
+	case "application/dtmf":
+		digit, err = parseDTMFDigit(request.Body)
+	default:
+		response := NewResponse(request, 415, "Unsupported Media Type")
+		response.Headers.Add("Accept", "application/dtmf-relay, application/dtmf")
+		return t.Respond(response)
+	}
+	if err != nil {
+		return t.Reply(400, "Bad Request")
+	}
+	if err := t.Reply(200, "OK"); err != nil {
+		return err
+	}
+	if s.OnDTMF != nil {
+		go s.OnDTMF(call, digit, duration)
+	}
+	return nil
+}
@@ -1,21 +1,93 @@
 package softphone
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 
-	glog "github.com/ghettovoice/gosip/log"
-	"github.com/ghettovoice/gosip/sip"
-	"github.com/ghettovoice/gosip/sip/parser"
 	"github.com/google/uuid"
 	"github.com/pion/interceptor"
 	"github.com/pion/webrtc/v3"
 )
 
-func (s *Softphone) Invite(extension string) {
+// Invite call to extension, returns when the call is answered or failed
+func (s *Softphone) Invite(extension string) (*Call, error) {
+	return s.InviteContext(context.Background(), extension)
+}
+
+// InviteContext call to extension, returns when the call is answered or failed.
+// The call is canceled if ctx is done before the answer.
+func (s *Softphone) InviteContext(ctx context.Context, extension string) (*Call, error) {
+	call, err := s.Dial(ctx, extension)
+	if err != nil {
+		return nil, err
+	}
+	if err := call.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return call, nil
+}
 
+// Dial start a call to extension, returns when INVITE is sent. Call.Wait waits for the answer,
+// Call.Cancel or ctx cancel the call while it is ringing.
+func (s *Softphone) Dial(ctx context.Context, extension string) (*Call, error) {
+	to := URI{Scheme: "sip", User: extension, Host: s.options.Domain}
+	return s.dial(ctx, to, URI{Scheme: "sip", Host: s.options.Domain}, nil)
+}
+
+// dial start a call to the address with the Request-URI, prepare can change the call and INVITE before it is sent
+func (s *Softphone) dial(ctx context.Context, to, requestURI URI, prepare func(call *Call, request *SipMessage)) (*Call, error) {
+	// Create a audio track
+	audioTrack := newAudioTrack("audio", "go")
+	peerConnection := s.newOfferingPeerConnection(audioTrack)
+
+	call := newCall(s, Dialog{
+		CallID:       uuid.New().String(),
+		LocalTag:     uuid.New().String(),
+		LocalURI:     NameAddr{URI: s.addressOfRecord()},
+		RemoteURI:    NameAddr{URI: to},
+		RemoteTarget: requestURI,
+		LocalSeq:     8082,
+	}, true, peerConnection, audioTrack)
+	call.handleTracks(peerConnection)
+
+	contact := s.contact()
+	contact.Params = Params{{"expires", "200"}}
+	call.mu.Lock()
+	requestMessage := call.newRequest("INVITE")
+	call.mu.Unlock()
+	requestMessage.Headers.Set("Contact", contact.String())
+	requestMessage.Headers.Add("Supported", "replaces, outbound,ice,100rel,timer")
+	requestMessage.Headers.Add("Session-Expires", SessionExpires{Delta: s.sessionExpires()}.String())
+	requestMessage.Headers.Add("Min-SE", strconv.Itoa(minSessionExpires))
+	requestMessage.Headers.Add("Content-Type", "application/sdp")
+	requestMessage.Body = peerConnection.LocalDescription().SDP
+	if prepare != nil {
+		prepare(call, &requestMessage)
+	}
+
+	t, err := call.sendInvite(requestMessage)
+	if err != nil {
+		call.close()
+		return nil, err
+	}
+	go call.dial(ctx, t)
+	return call, nil
+}
+
+// newOfferingPeerConnection PeerConnection of outgoing call with the audio track and local offer,
+// ICE candidates are gathered
+func (s *Softphone) newOfferingPeerConnection(audioTrack *AudioTrack) *webrtc.PeerConnection {
 	mediaEngine := webrtc.MediaEngine{}
+	if s.options.G711 {
+		// PCMU and PCMA are offered before Opus of the default codecs
+		registerG711(&mediaEngine)
+	}
 	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
 		panic(err)
 	}
@@ -25,6 +97,7 @@ func (s *Softphone) Invite(extension string) {
 	}, webrtc.RTPCodecTypeAudio); err != nil {
 		panic(err)
 	}
+	registerTelephoneEvent(&mediaEngine)
 
 	settingEngine := webrtc.SettingEngine{}
 
@@ -47,11 +120,6 @@ func (s *Softphone) Invite(extension string) {
 		panic(err)
 	}
 
-	// Create a audio track
-	audioTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 8000}, "audio", "go")
-	if err != nil {
-		panic(err)
-	}
 	_, err = peerConnection.AddTrack(audioTrack)
 	if err != nil {
 		panic(err)
@@ -61,13 +129,6 @@ func (s *Softphone) Invite(extension string) {
 		fmt.Printf(">>> OnICEConnectionStateChange: %s <<<\n", connectionState.String())
 	})
 
-	peerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
-
-		if s.OnTrack != nil {
-			s.OnTrack(track, audioTrack)
-		}
-	})
-
 	peerConnection.OnICEGatheringStateChange(func(state webrtc.ICEGathererState) {
 		fmt.Printf(">>> OnICEGatheringStateChange: %s <<<\n", state)
 	})
@@ -91,124 +152,331 @@ func (s *Softphone) Invite(extension string) {
 		panic(err)
 	}
 	<-gatherComplete
+	return peerConnection
+}
 
-	requestMessage := SipMessage{
-		Subject: fmt.Sprintf("INVITE sip:%s SIP/2.0", s.options.Domain),
-		Headers: map[string]string{
-			"Contact":      fmt.Sprintf("<sip:%s;transport=%s>;expires=200", fakeEmail, strings.ToLower(s.options.Transport)),
-			"To":           fmt.Sprintf("<sip:%s@%s>", extension, s.options.Domain),
-			"Via":          fmt.Sprintf("SIP/2.0/%s %s;branch=z9hG4bK%s", strings.ToUpper(s.options.Transport), fakeDomain, uuid.New().String()),
-			"From":         fmt.Sprintf("<sip:%s@%s>;tag=%s", s.options.Username, s.options.Domain, s.fromTag),
-			"Call-ID":      s.callID,
-			"Supported":    "replaces, outbound,ice",
-			"Content-Type": "application/sdp",
-			"CSeq":         "8083 INVITE",
-			"Max-Forwards": "70",
-		},
-		Body: peerConnection.LocalDescription().SDP,
-	}
-	s.Send(requestMessage, func(strMessage string) bool {
-		logger := glog.NewDefaultLogrusLogger()
-		msg, err := parser.ParseMessage([]byte(strMessage), logger)
-		if err != nil {
-			panic(err)
-		}
-
-		if strings.Contains(msg.StartLine(), "SIP/2.0 407 Proxy Authentication Required") {
-			headers := msg.GetHeaders("Proxy-Authenticate")
-			auth := sip.AuthFromValue(headers[0].Value()).
-				SetMethod("INVITE").
-				SetUsername(s.options.Username).
-				SetPassword(s.options.Password)
-			auth.SetResponse(auth.CalcResponse())
-
-			requestAuthMessage := SipMessage{
-				Subject: fmt.Sprintf("INVITE sip:%s SIP/2.0", s.options.Domain),
-				Headers: map[string]string{
-					"Contact":      requestMessage.Headers["Contact"],
-					"To":           requestMessage.Headers["To"],
-					"Via":          requestMessage.Headers["Via"],
-					"From":         requestMessage.Headers["From"],
-					"Call-ID":      requestMessage.Headers["Call-ID"],
-					"Supported":    requestMessage.Headers["Supported"],
-					"Content-Type": requestMessage.Headers["Content-Type"],
-					"CSeq":         requestMessage.Headers["CSeq"],
-					"Max-Forwards": requestMessage.Headers["Max-Forwards"],
-				},
-				Body: peerConnection.LocalDescription().SDP,
-			}
+// handleTracks pass remote tracks of the PeerConnection to OnTrack
+func (c *Call) handleTracks(peerConnection *webrtc.PeerConnection) {
+	peerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		if c.s.OnTrack != nil {
+			c.s.OnTrack(newRemoteTrack(track, c), c.audioTrack)
+		}
+	})
+}
 
-			requestAuthMessage.Headers["Proxy-Authorization"] = auth.String()
-			requestAuthMessage.IncreaseSeq()
-			s.Send(requestAuthMessage, func(strMessage string) bool {
+// sendInvite send INVITE in a new client transaction, the call can be canceled after a provisional response
+func (c *Call) sendInvite(request SipMessage) (*ClientTransaction, error) {
+	cseq, err := request.CSeq()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.invite = request
+	c.dialog.LocalSeq = cseq.Seq
+	c.proceeding = false
+	c.rseq = 0
+	c.mu.Unlock()
+	return c.s.Request(request, c.handleInviteResponse)
+}
 
-				logger := glog.NewDefaultLogrusLogger()
-				msg, err = parser.ParseMessage([]byte(strMessage), logger)
-				if err != nil {
-					panic(err)
-				}
+// handleInviteResponse handler of all responses to INVITE sent by us
+func (c *Call) handleInviteResponse(response SipMessage) {
+	code := response.StatusCode()
+	if code < 200 {
+		c.handleProvisional(response)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if code < 300 && c.ack != nil {
+		// retransmitted 2xx, RFC 3261 13.2.2.4
+		ack := *c.ack
+		go func() {
+			if err := c.s.Send(ack); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+}
 
-				if !strings.Contains(msg.StartLine(), "200 OK") {
-					return false
-				}
+// handleProvisional send pending CANCEL, update the early dialog, PRACK reliable response, apply early media
+// and report the response to OnProgress
+func (c *Call) handleProvisional(response SipMessage) {
+	c.mu.Lock()
+	reliable := response.HasOptionTag("Require", "100rel")
+	if reliable {
+		rseq, err := strconv.ParseUint(response.Headers.Get("RSeq"), 10, 32)
+		if err != nil || (c.rseq != 0 && uint32(rseq) != c.rseq+1) {
+			// retransmission or out of order reliable provisional response is discarded, RFC 3262 4
+			c.mu.Unlock()
+			return
+		}
+		c.rseq = uint32(rseq)
+	}
+	if !c.proceeding && c.canceling {
+		go c.sendCancel(c.invite)
+	}
+	c.proceeding = true
+	if to, err := response.To(); err == nil && to.Tag() != "" && !c.established {
+		// early dialog, RFC 3261 12.1.2
+		if err := c.updateFromResponse(response); err != nil {
+			log.Println(err)
+		}
+	}
+	early := c.earlySDP == "" && hasSDP(response)
+	if early {
+		c.earlySDP = response.Body
+		if to, err := response.To(); err == nil {
+			c.earlyTag = to.Tag()
+		}
+	}
+	var prack SipMessage
+	if reliable {
+		prack = c.newPrack()
+	}
+	peerConnection := c.peerConnection
+	c.mu.Unlock()
 
-				rsd := webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: patchFreeSwitchSDP(msg.Body())}
-				if err := peerConnection.SetRemoteDescription(rsd); err != nil {
-					panic(err)
-				}
+	if reliable {
+		go c.sendPrack(prack)
+	}
 
-				cseq, _ := msg.CSeq()
-				cseq.MethodName = "ACK"
-
-				responseMessage := SipMessage{
-					Subject: fmt.Sprintf("ACK sip:%s@%s SIP/2.0", s.options.Username, s.options.Domain),
-					Headers: map[string]string{
-						"Contact":      fmt.Sprintf("<sip:%s;transport=%s>;expires=200", fakeEmail, strings.ToLower(s.options.Transport)),
-						"To":           msg.GetHeaders("To")[0].Value(),
-						"Via":          fmt.Sprintf("SIP/2.0/%s %s;branch=z9hG4bK%s", strings.ToUpper(s.options.Transport), fakeDomain, uuid.New().String()),
-						"From":         msg.GetHeaders("From")[0].Value(),
-						"Call-ID":      s.callID,
-						"CSeq":         cseq.Value(),
-						"Max-Forwards": "70",
-					},
-					Body: "",
-				}
-				s.Send(responseMessage, nil)
+	if early {
+		// the SDP of 18x is the answer, 2xx of the same dialog has the same SDP, RFC 3261 13.2.1
+		rsd := webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: patchFreeSwitchSDP(response.Body)}
+		if err := peerConnection.SetRemoteDescription(rsd); err != nil {
+			log.Println(err)
+		}
+	}
+	if c.progress != nil {
+		c.progress(response)
+	}
+	if c.s.OnProgress != nil {
+		// the transaction isn't held by a slow callback
+		go c.s.OnProgress(c, response)
+	}
+}
 
-				return true
+// hasSDP reports whether the message has SDP body
+func hasSDP(sipMessage SipMessage) bool {
+	contentType := strings.ToLower(sipMessage.Headers.Get("Content-Type"))
+	return sipMessage.Body != "" && strings.HasPrefix(contentType, "application/sdp")
+}
 
-			})
+// dial wait for the final response to INVITE, answer authentication challenge, follow redirects and ACK 2xx
+func (c *Call) dial(ctx context.Context, t *ClientTransaction) {
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := c.Cancel(); err != nil && err != ErrCallNotRinging {
+				log.Println(err)
+			}
+		case <-c.answered:
+		}
+	}()
 
-			return true
+	authorized := false
+	retried := false
+	redirection := newRedirection(t.Request)
+	for {
+		response, err := t.Response()
+		if err != nil {
+			c.finishDial(err)
+			return
+		}
+		code := response.StatusCode()
+
+		c.mu.Lock()
+		canceling := c.canceling
+		c.mu.Unlock()
+
+		if (code == 401 || code == 407) && !authorized && !canceling {
+			authorized = true
+			request, err := c.s.authorize(t.Request, response)
+			if err != nil {
+				c.finishDial(err)
+				return
+			}
+			if t, err = c.sendInvite(request); err != nil {
+				c.finishDial(err)
+				return
+			}
+			continue
+		}
+		if code == 422 && !retried && !canceling {
+			retried = true
+			request, err := c.s.retryWithMinSE(t.Request, response)
+			if err != nil {
+				c.finishDial(err)
+				return
+			}
+			if t, err = c.sendInvite(request); err != nil {
+				c.finishDial(err)
+				return
+			}
+			continue
+		}
+		if code >= 300 {
+			if canceling {
+				c.finishDial(ErrCallCanceled)
+				return
+			}
+			request, ok, err := c.redirect(redirection, t.Request, response)
+			if err != nil {
+				c.finishDial(err)
+				return
+			}
+			if ok {
+				// the new target can challenge and negotiate the session interval again
+				authorized, retried = false, false
+				if t, err = c.sendInvite(request); err != nil {
+					c.finishDial(err)
+					return
+				}
+				continue
+			}
+			c.finishDial(newResponseError(response))
+			return
 		}
 
-		if !strings.Contains(msg.StartLine(), "200 OK") {
-			return false
+		err = c.accept(response)
+		if err == nil && canceling {
+			// 2xx crossed CANCEL, the call is ended by BYE, RFC 3261 9.1
+			if err := c.Hangup(); err != nil {
+				log.Println(err)
+			}
+			err = ErrCallCanceled
 		}
+		c.finishDial(err)
+		return
+	}
+}
 
-		rsd := webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: patchFreeSwitchSDP(msg.Body())}
+// accept apply 2xx response to INVITE sent by us: remote SDP unless early media of the same dialog is set up,
+// dialog state and ACK
+func (c *Call) accept(response SipMessage) error {
+	c.mu.Lock()
+	early := c.earlySDP != ""
+	earlyTag := c.earlyTag
+	peerConnection := c.peerConnection
+	c.mu.Unlock()
+	if !early {
+		rsd := webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: patchFreeSwitchSDP(response.Body)}
 		if err := peerConnection.SetRemoteDescription(rsd); err != nil {
-			panic(err)
-		}
-
-		cseq, _ := msg.CSeq()
-		cseq.MethodName = "ACK"
-
-		responseMessage := SipMessage{
-			Subject: fmt.Sprintf("ACK sip:%s@%s SIP/2.0", s.options.Username, s.options.Domain),
-			Headers: map[string]string{
-				"Contact":      fmt.Sprintf("<sip:%s;transport=%s>;expires=200", fakeEmail, strings.ToLower(s.options.Transport)),
-				"To":           msg.GetHeaders("To")[0].Value(),
-				"Via":          fmt.Sprintf("SIP/2.0/%s %s;branch=z9hG4bK%s", strings.ToUpper(s.options.Transport), fakeDomain, uuid.New().String()),
-				"From":         msg.GetHeaders("From")[0].Value(),
-				"Call-ID":      s.callID,
-				"CSeq":         cseq.Value(),
-				"Max-Forwards": "70",
-			},
-			Body: "",
+			return err
 		}
-		s.Send(responseMessage, nil)
+	} else if to, err := response.To(); err == nil && to.Tag() != earlyTag && hasSDP(response) {
+		// the INVITE forked, 2xx comes from another dialog than the early media
+		if err := c.reapplyAnswer(response.Body); err != nil {
+			return err
+		}
+	}
 
-		return true
-	})
+	c.mu.Lock()
+	err := c.updateFromResponse(response)
+	ack := c.newRequest("ACK")
+	c.ack = &ack
+	c.remoteAllowsUpdate = response.HasOptionTag("Allow", "UPDATE")
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := c.s.Send(ack); err != nil {
+		return err
+	}
+	c.confirm()
+	c.sessionTimerFromResponse(response)
+	return nil
+}
+
+// reapplyAnswer apply answer of another dialog to the offer of INVITE. The PeerConnection is stable
+// after the early answer, so the same offer is set again before the answer. Codecs and tracks follow
+// the new answer, pion keeps the DTLS transport started by the early answer.
+func (c *Call) reapplyAnswer(sdp string) error {
+	peerConnection := c.PeerConnection()
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	if err := peerConnection.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	rsd := webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: patchFreeSwitchSDP(sdp)}
+	return peerConnection.SetRemoteDescription(rsd)
+}
+
+// finishDial report the result of dialing, the PeerConnection is closed on failure
+func (c *Call) finishDial(err error) {
+	if err != nil {
+		c.close()
+	}
+	c.mu.Lock()
+	c.dialErr = err
+	c.mu.Unlock()
+	close(c.answered)
+}
+
+// Wait wait until the outgoing call is answered, returns the reason if it failed
+func (c *Call) Wait() error {
+	<-c.answered
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dialErr
+}
+
+// Cancel cancel the outgoing call before it is answered. CANCEL is sent when the call got
+// a provisional response, Wait returns ErrCallCanceled when the call is ended.
+func (c *Call) Cancel() error {
+	c.mu.Lock()
+	if !c.outgoing || c.canceling {
+		c.mu.Unlock()
+		return ErrCallNotRinging
+	}
+	select {
+	case <-c.answered:
+		c.mu.Unlock()
+		return ErrCallNotRinging
+	default:
+	}
+	c.canceling = true
+	proceeding := c.proceeding
+	invite := c.invite
+	c.mu.Unlock()
+
+	if !proceeding {
+		return nil
+	}
+	return c.sendCancel(invite)
+}
+
+// sendCancel send CANCEL for INVITE without waiting for its response, RFC 3261 9.1
+func (c *Call) sendCancel(invite SipMessage) error {
+	cseq, err := invite.CSeq()
+	if err != nil {
+		return err
+	}
+	uri, err := invite.RequestURI()
+	if err != nil {
+		return err
+	}
+	cancel := SipMessage{
+		Subject: fmt.Sprintf("CANCEL %s SIP/2.0", uri),
+		Headers: invite.Headers.Filter("Via", "Route", "Max-Forwards", "From", "To", "Call-ID"),
+	}
+	cancel.Headers.Add("CSeq", CSeq{Seq: cseq.Seq, Method: "CANCEL"}.String())
+	t, err := c.s.Request(cancel, nil)
+	if err != nil {
+		return err
+	}
+	// the result of CANCEL is the final response to INVITE
+	go func() {
+		response, err := t.Response()
+		if err == nil && response.StatusCode() >= 300 {
+			err = newResponseError(response)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	}()
+	return nil
 }
@@ -0,0 +1,34 @@
+package softphone
+
+// SendMessage send instant message to target, extension or SIP URI, by MESSAGE, RFC 3428.
+// Returns the final response, e.g. 202 for the message accepted for later delivery, and ResponseError
+// with the response if the message is rejected.
+func (s *Softphone) SendMessage(to, contentType, body string) (SipMessage, error) {
+	uri, err := s.targetURI(to)
+	if err != nil {
+		return SipMessage{}, err
+	}
+	request := s.newRequest("MESSAGE", uri)
+	request.Headers.Add("Content-Type", contentType)
+	request.Body = body
+	_, response, err := s.requestWithAuth(request)
+	if err != nil {
+		return SipMessage{}, err
+	}
+	if response.StatusCode() >= 300 {
+		return response, newResponseError(response)
+	}
+	return response, nil
+}
+
+// handleMessage answer MESSAGE and pass it to OnMessage
+func (s *Softphone) handleMessage(t *ServerTransaction) error {
+	if s.OnMessage == nil {
+		return t.Reply(480, "Temporarily Unavailable")
+	}
+	if err := t.Reply(200, "OK"); err != nil {
+		return err
+	}
+	go s.OnMessage(t.Request())
+	return nil
+}
@@ -0,0 +1,142 @@
+package softphone
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// MessageCounts counts of messages of one class, "2/8 (0/2)"
+type MessageCounts struct {
+	New       int
+	Old       int
+	UrgentNew int
+	UrgentOld int
+}
+
+// MessageSummary message waiting indication, application/simple-message-summary, RFC 3842 5.2
+type MessageSummary struct {
+	MessagesWaiting bool
+	MessageAccount  string
+	// Messages counts by lower case message class: "voice-message", "fax-message" and others
+	Messages map[string]MessageCounts
+}
+
+// Voice counts of voice messages
+func (summary MessageSummary) Voice() MessageCounts {
+	return summary.Messages["voice-message"]
+}
+
+// ParseMessageSummary parse message summary, message headers after the summary are ignored
+func ParseMessageSummary(body string) (MessageSummary, error) {
+	summary := MessageSummary{Messages: map[string]MessageCounts{}}
+	hasStatus := false
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return MessageSummary{}, &ParseError{Reason: fmt.Sprintf("invalid message summary line %q", line)}
+		}
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+		switch name {
+		case "messages-waiting":
+			switch strings.ToLower(value) {
+			case "yes":
+				summary.MessagesWaiting = true
+			case "no":
+			default:
+				return MessageSummary{}, &ParseError{Reason: fmt.Sprintf("invalid Messages-Waiting %q", value)}
+			}
+			hasStatus = true
+		case "message-account":
+			summary.MessageAccount = value
+		default:
+			counts, err := parseMessageCounts(value)
+			if err != nil {
+				return MessageSummary{}, err
+			}
+			summary.Messages[name] = counts
+		}
+	}
+	if !hasStatus {
+		return MessageSummary{}, &ParseError{Reason: "message summary without Messages-Waiting"}
+	}
+	return summary, nil
+}
+
+// parseMessageCounts parse "2/8" or "2/8 (0/2)"
+func parseMessageCounts(s string) (MessageCounts, error) {
+	counts := MessageCounts{}
+	total := s
+	urgent := ""
+	if open := strings.IndexByte(s, '('); open >= 0 {
+		if !strings.HasSuffix(s, ")") {
+			return MessageCounts{}, &ParseError{Reason: fmt.Sprintf("invalid message counts %q", s)}
+		}
+		total = s[:open]
+		urgent = s[open+1 : len(s)-1]
+	}
+	var err error
+	if counts.New, counts.Old, err = parseNewOld(total); err != nil {
+		return MessageCounts{}, err
+	}
+	if urgent != "" {
+		if counts.UrgentNew, counts.UrgentOld, err = parseNewOld(urgent); err != nil {
+			return MessageCounts{}, err
+		}
+	}
+	return counts, nil
+}
+
+// parseNewOld parse "2/8"
+func parseNewOld(s string) (int, int, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, &ParseError{Reason: fmt.Sprintf("invalid message counts %q", s)}
+	}
+	newCount, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, &ParseError{Reason: fmt.Sprintf("invalid message counts %q", s)}
+	}
+	oldCount, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, &ParseError{Reason: fmt.Sprintf("invalid message counts %q", s)}
+	}
+	return newCount, oldCount, nil
+}
+
+// SubscribeMessageWaiting subscribe to message-summary event of our own account, RFC 3842.
+// Summaries go to OnMessageWaiting.
+func (s *Softphone) SubscribeMessageWaiting(expires uint32) (*Subscription, error) {
+	return s.Subscribe(s.addressOfRecord().String(), "message-summary", "application/simple-message-summary", expires)
+}
+
+// handleUnsolicitedMessageSummary answer NOTIFY of message-summary sent without subscription
+func (s *Softphone) handleUnsolicitedMessageSummary(t *ServerTransaction) error {
+	if _, err := ParseMessageSummary(t.Request().Body); err != nil {
+		return t.Reply(400, "Bad Request")
+	}
+	if err := t.Reply(200, "OK"); err != nil {
+		return err
+	}
+	go s.messageWaiting(t.Request())
+	return nil
+}
+
+// messageWaiting pass summary of NOTIFY to OnMessageWaiting, NOTIFY without body is skipped
+func (s *Softphone) messageWaiting(notify SipMessage) {
+	if s.OnMessageWaiting == nil || strings.TrimSpace(notify.Body) == "" {
+		return
+	}
+	summary, err := ParseMessageSummary(notify.Body)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	s.OnMessageWaiting(summary)
+}
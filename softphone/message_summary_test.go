@@ -0,0 +1,72 @@
+package softphone
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMessageSummary(t *testing.T) {
+	// RFC 3842 5.2 with message headers of a new message after the blank line
+	body := "Messages-Waiting: yes\r\n" +
+		"Message-Account: sip:alice@vmail.example.com\r\n" +
+		"Voice-Message: 4/8 (1/2)\r\n" +
+		"Fax-Message: 0/1\r\n" +
+		"\r\n" +
+		"To: <alice@atlanta.example.com>\r\n" +
+		"From: <bob@biloxi.example.com>\r\n" +
+		"Subject: carpool tomorrow?\r\n" +
+		"Priority: normal\r\n" +
+		"Message-ID: 13784434989@vmail.example.com\r\n"
+	summary, err := ParseMessageSummary(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := MessageSummary{
+		MessagesWaiting: true,
+		MessageAccount:  "sip:alice@vmail.example.com",
+		Messages: map[string]MessageCounts{
+			"voice-message": {New: 4, Old: 8, UrgentNew: 1, UrgentOld: 2},
+			"fax-message":   {New: 0, Old: 1},
+		},
+	}
+	if !reflect.DeepEqual(summary, expected) {
+		t.Errorf("summary\n%+v\nexpected\n%+v", summary, expected)
+	}
+	if voice := summary.Voice(); voice != expected.Messages["voice-message"] {
+		t.Errorf("voice %+v", voice)
+	}
+}
+
+func TestParseMessageSummaryNoMessages(t *testing.T) {
+	summary, err := ParseMessageSummary("messages-waiting: NO\nvoice-message: 0/3 ( 0 / 0 )\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.MessagesWaiting || summary.MessageAccount != "" {
+		t.Errorf("summary %+v", summary)
+	}
+	if voice := summary.Voice(); voice != (MessageCounts{Old: 3}) {
+		t.Errorf("voice %+v", voice)
+	}
+}
+
+func TestParseMessageSummaryInvalid(t *testing.T) {
+	for _, body := range []string{
+		"",
+		"Voice-Message: 1/0\r\n",
+		"Message-Account: sip:alice@vmail.example.com\r\nVoice-Message: 1/0\r\n",
+		// Messages-Waiting only in the message headers after the summary
+		"Voice-Message: 1/0\r\n\r\nMessages-Waiting: yes\r\n",
+		"Messages-Waiting: maybe\r\n",
+		"Messages-Waiting: yes\r\nVoice-Message: 1\r\n",
+		"Messages-Waiting: yes\r\nVoice-Message: 1/0 (1/0\r\n",
+		"Messages-Waiting: yes\r\nVoice-Message: 1/0 (x/0)\r\n",
+		"Messages-Waiting: yes\r\nVoice-Message\r\n",
+	} {
+		if summary, err := ParseMessageSummary(body); err == nil {
+			t.Errorf("%q parsed as %+v", body, summary)
+		} else if _, ok := err.(*ParseError); !ok {
+			t.Errorf("%q error %v isn't ParseError", body, err)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package softphone
+
+import (
+	"fmt"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// ParseError error of parsing SIP message
+type ParseError struct {
+	// Line number of the message line with the error, 0 for errors of the whole message
+	Line   int
+	Reason string
+}
+
+func (e *ParseError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("sip parse error at line %d: %s", e.Line, e.Reason)
+	}
+	return fmt.Sprintf("sip parse error: %s", e.Reason)
+}
+
+// compactHeaders compact forms of header names, RFC 3261 7.3.3 and later extensions
+var compactHeaders = map[string]string{
+	"a": "Accept-Contact",
+	"b": "Referred-By",
+	"c": "Content-Type",
+	"d": "Request-Disposition",
+	"e": "Content-Encoding",
+	"f": "From",
+	"i": "Call-ID",
+	"j": "Reject-Contact",
+	"k": "Supported",
+	"l": "Content-Length",
+	"m": "Contact",
+	"o": "Event",
+	"r": "Refer-To",
+	"s": "Subject",
+	"t": "To",
+	"u": "Allow-Events",
+	"v": "Via",
+	"x": "Session-Expires",
+	"y": "Identity",
+}
+
+// canonicalHeaders header names which textproto can't canonicalize
+var canonicalHeaders = map[string]string{
+	"call-id":          "Call-ID",
+	"cseq":             "CSeq",
+	"mime-version":     "MIME-Version",
+	"rack":             "RAck",
+	"rseq":             "RSeq",
+	"sip-etag":         "SIP-ETag",
+	"sip-if-match":     "SIP-If-Match",
+	"www-authenticate": "WWW-Authenticate",
+	"min-se":           "Min-SE",
+}
+
+// singleHeaders headers which can't be repeated in a message
+var singleHeaders = []string{"From", "To", "Call-ID", "CSeq", "Max-Forwards", "Content-Length", "Content-Type"}
+
+// CanonicalHeaderName full canonical form of header name, compact forms are expanded
+func CanonicalHeaderName(name string) string {
+	lower := strings.ToLower(name)
+	if full, ok := compactHeaders[lower]; ok {
+		return full
+	}
+	if full, ok := canonicalHeaders[lower]; ok {
+		return full
+	}
+	return textproto.CanonicalMIMEHeaderKey(name)
+}
+
+// ParseSipMessage parse SIP message received from the transport
+func ParseSipMessage(message string) (SipMessage, error) {
+	// RFC 3261 7.5: CRLFs preceding the start-line are ignored
+	message = strings.TrimLeft(message, "\r\n")
+
+	end := strings.Index(message, "\r\n\r\n")
+	if end < 0 {
+		return SipMessage{}, &ParseError{Reason: "no empty line after headers"}
+	}
+	head := message[:end]
+	body := message[end+4:]
+
+	lines := strings.Split(head, "\r\n")
+	subject := lines[0]
+	if err := parseStartLine(subject); err != nil {
+		return SipMessage{}, err
+	}
+
+	headers := Headers{}
+	for n, line := range lines[1:] {
+		lineNumber := n + 2
+		// LWS folding, the line continues the previous header
+		if line != "" && (line[0] == ' ' || line[0] == '\t') {
+			if len(headers) == 0 {
+				return SipMessage{}, &ParseError{Line: lineNumber, Reason: "continuation line without header"}
+			}
+			last := &headers[len(headers)-1]
+			value := strings.Trim(line, " \t")
+			if last.Value == "" {
+				last.Value = value
+			} else if value != "" {
+				last.Value = last.Value + " " + value
+			}
+			continue
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			return SipMessage{}, &ParseError{Line: lineNumber, Reason: fmt.Sprintf("no colon in header %q", line)}
+		}
+		name := strings.TrimRight(line[:colon], " \t")
+		if !isToken(name) {
+			return SipMessage{}, &ParseError{Line: lineNumber, Reason: fmt.Sprintf("invalid header name %q", name)}
+		}
+		headers.Add(CanonicalHeaderName(name), strings.Trim(line[colon+1:], " \t"))
+	}
+
+	for _, name := range singleHeaders {
+		if len(headers.Values(name)) > 1 {
+			return SipMessage{}, &ParseError{Reason: fmt.Sprintf("header %s is repeated", name)}
+		}
+	}
+	for _, name := range []string{"Via", "From", "To", "Call-ID", "CSeq"} {
+		if !headers.Has(name) {
+			return SipMessage{}, &ParseError{Reason: fmt.Sprintf("header %s is missing", name)}
+		}
+	}
+
+	sipMessage := SipMessage{
+		Subject: subject,
+		Headers: headers,
+	}
+
+	cseq := strings.Fields(headers.Get("CSeq"))
+	if len(cseq) != 2 {
+		return SipMessage{}, &ParseError{Reason: fmt.Sprintf("invalid CSeq %q", headers.Get("CSeq"))}
+	}
+	if _, err := strconv.ParseUint(cseq[0], 10, 31); err != nil {
+		return SipMessage{}, &ParseError{Reason: fmt.Sprintf("invalid CSeq number %q", cseq[0])}
+	}
+	if sipMessage.IsRequest() && cseq[1] != sipMessage.Method() {
+		return SipMessage{}, &ParseError{Reason: fmt.Sprintf("CSeq method %s doesn't match request method %s", cseq[1], sipMessage.Method())}
+	}
+
+	if headers.Has("Max-Forwards") {
+		if _, err := strconv.ParseUint(headers.Get("Max-Forwards"), 10, 8); err != nil {
+			return SipMessage{}, &ParseError{Reason: fmt.Sprintf("invalid Max-Forwards %q", headers.Get("Max-Forwards"))}
+		}
+	}
+
+	// Message oriented transport: the body ends with the message when there is no Content-Length,
+	// data after Content-Length bytes is discarded, RFC 3261 18.3
+	if headers.Has("Content-Length") {
+		length, err := strconv.ParseUint(headers.Get("Content-Length"), 10, 31)
+		if err != nil {
+			return SipMessage{}, &ParseError{Reason: fmt.Sprintf("invalid Content-Length %q", headers.Get("Content-Length"))}
+		}
+		if int(length) > len(body) {
+			return SipMessage{}, &ParseError{Reason: fmt.Sprintf("Content-Length %d is larger than body %d", length, len(body))}
+		}
+		body = body[:length]
+	}
+	sipMessage.Body = body
+
+	return sipMessage, nil
+}
+
+// parseStartLine check Request-Line or Status-Line
+func parseStartLine(line string) error {
+	if strings.HasPrefix(line, "SIP/") {
+		tokens := strings.SplitN(line, " ", 3)
+		if len(tokens) < 2 {
+			return &ParseError{Line: 1, Reason: fmt.Sprintf("invalid status line %q", line)}
+		}
+		if !strings.EqualFold(tokens[0], "SIP/2.0") {
+			return &ParseError{Line: 1, Reason: fmt.Sprintf("unsupported version %s", tokens[0])}
+		}
+		code, err := strconv.Atoi(tokens[1])
+		if err != nil || len(tokens[1]) != 3 || code < 100 || code > 699 {
+			return &ParseError{Line: 1, Reason: fmt.Sprintf("invalid status code %q", tokens[1])}
+		}
+		return nil
+	}
+
+	tokens := strings.Split(line, " ")
+	if len(tokens) != 3 {
+		return &ParseError{Line: 1, Reason: fmt.Sprintf("invalid request line %q", line)}
+	}
+	if !isToken(tokens[0]) {
+		return &ParseError{Line: 1, Reason: fmt.Sprintf("invalid method %q", tokens[0])}
+	}
+	uri := tokens[1]
+	colon := strings.IndexByte(uri, ':')
+	if colon <= 0 || strings.ContainsAny(uri, "<>\t") {
+		return &ParseError{Line: 1, Reason: fmt.Sprintf("invalid Request-URI %q", uri)}
+	}
+	if !strings.EqualFold(tokens[2], "SIP/2.0") {
+		return &ParseError{Line: 1, Reason: fmt.Sprintf("unsupported version %s", tokens[2])}
+	}
+	return nil
+}
+
+// isToken token from RFC 3261 25.1
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("-.!%*_+`'~", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,563 @@
+package softphone
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// crlf message of RFC 4475 with CRLF line ends
+func crlf(message string) string {
+	return strings.ReplaceAll(message, "\n", "\r\n")
+}
+
+// RFC 4475 3.1.1 valid messages
+func TestParseSipMessageValid(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		check   func(t *testing.T, sipMessage SipMessage)
+	}{
+		{
+			// 3.1.1.1 A Short Tortuous INVITE
+			name: "wsinv",
+			message: crlf(`INVITE sip:vivekg@chair-dnrc.example.com;unknownparam SIP/2.0
+TO :
+ sip:vivekg@chair-dnrc.example.com ;   tag    = 1918181833n
+from   : "J Rosenberg \\\""       <sip:jdrosen@example.com>
+  ;
+  tag = 98asjd8
+MaX-fOrWaRdS: 0068
+Call-ID: wsinv.ndaksdj@192.0.2.1
+Content-Length   : 150
+cseq: 0009
+  INVITE
+Via  : SIP  /   2.0
+ /UDP
+    192.0.2.2;branch=390skdjuw
+s :
+NewFangledHeader:   newfangled value
+ continued newfangled value
+UnknownHeaderWithUnusualValue: ;;,,;;,;
+Content-Type: application/sdp
+Route:
+ <sip:services.example.com;lr;unknownwith=value;unknown-no-value>
+v:  SIP  / 2.0  / TCP     spindle.example.com   ;
+  branch  =   z9hG4bK9ikj8  ,
+ SIP  /    2.0   / UDP  192.168.255.111   ; branch=
+ z9hG4bK30239
+m:"Quoted string \"\"" <sip:jdrosen@example.com> ; newparam =
+      newvalue ;
+  secondparam ; q = 0.33
+
+v=0
+o=mhandley 29739 7272939 IN IP4 192.0.2.3
+s=-
+c=IN IP4 192.0.2.4
+t=0 0
+m=audio 49217 RTP/AVP 0 12
+m=video 3227 RTP/AVP 31
+a=rtpmap:31 LPC
+`),
+			check: func(t *testing.T, sipMessage SipMessage) {
+				if sipMessage.Method() != "INVITE" {
+					t.Errorf("method %q", sipMessage.Method())
+				}
+				expected := map[string]string{
+					"To":               "sip:vivekg@chair-dnrc.example.com ;   tag    = 1918181833n",
+					"From":             `"J Rosenberg \\\""       <sip:jdrosen@example.com> ; tag = 98asjd8`,
+					"Max-Forwards":     "0068",
+					"Call-ID":          "wsinv.ndaksdj@192.0.2.1",
+					"CSeq":             "0009 INVITE",
+					"Subject":          "",
+					"NewFangledHeader": "newfangled value continued newfangled value",
+					"Contact":          `"Quoted string \"\"" <sip:jdrosen@example.com> ; newparam = newvalue ; secondparam ; q = 0.33`,
+				}
+				for name, value := range expected {
+					if got := sipMessage.Headers.Get(name); got != value {
+						t.Errorf("%s %q, expected %q", name, got, value)
+					}
+				}
+				if vias := sipMessage.Headers.Values("Via"); len(vias) != 2 {
+					t.Errorf("Via %q", vias)
+				}
+				cseq, err := sipMessage.CSeq()
+				if err != nil || cseq.Seq != 9 || cseq.Method != "INVITE" {
+					t.Errorf("CSeq %v %v", cseq, err)
+				}
+				if len(sipMessage.Body) != 150 || !strings.HasSuffix(sipMessage.Body, "a=rtpmap:31 LPC\r\n") {
+					t.Errorf("body %q", sipMessage.Body)
+				}
+			},
+		},
+		{
+			// 3.1.1.2 Wide Range of Valid Characters
+			name: "intmeth",
+			message: crlf(`!interesting-Method0123456789_*+` + "`" + `.%indeed'~ sip:1_unusual.URI~(to-be!sure)&isn't+it$/crazy?,/;;*:&it+has=1,weird!*pas$wo~d_too.(doesn't-it)@example.com SIP/2.0
+Via: SIP/2.0/TCP host1.example.com;branch=z9hG4bK-.!%66*_+` + "`" + `'~
+To: "BEL:\ NUL:\  DEL:\" <sip:1_unusual.URI~(to-be!sure)&isn't+it$/crazy?,/;;*@example.com>
+From: token1~` + "`" + `token2'+_token3*%!.token4 <sip:mundane@example.com>;tag=_token~1'+` + "`" + `*%!-.
+Call-ID: intmeth.word%ZK-!.*_+'@word` + "`" + `~)(><:\/"][?}{
+CSeq: 139122385 !interesting-Method0123456789_*+` + "`" + `.%indeed'~
+Max-Forwards: 255
+extensionHeader-!.%*+_` + "`" + `'~:` + "\ufeff大停電" + `
+Content-Length: 0
+
+`),
+			check: func(t *testing.T, sipMessage SipMessage) {
+				if sipMessage.Method() != "!interesting-Method0123456789_*+`.%indeed'~" {
+					t.Errorf("method %q", sipMessage.Method())
+				}
+				if got := sipMessage.Headers.Get("Call-ID"); got != "intmeth.word%ZK-!.*_+'@word`~)(><:\\/\"][?}{" {
+					t.Errorf("Call-ID %q", got)
+				}
+				if !sipMessage.Headers.Has("extensionHeader-!.%*+_`'~") {
+					t.Errorf("extension header is missing: %v", sipMessage.Headers)
+				}
+				if sipMessage.Body != "" {
+					t.Errorf("body %q", sipMessage.Body)
+				}
+			},
+		},
+		{
+			// 3.1.1.3 Valid Use of the % Escaping Mechanism
+			name: "esc01",
+			message: crlf(`INVITE sip:sips%3Auser%40example.com@example.net SIP/2.0
+To: sip:%75se%72@example.com
+From: <sip:I%20have%20spaces@example.net>;tag=938
+Max-Forwards: 87
+i: esc01.239409asdfakjkn23onasd0-3234
+CSeq: 234234 INVITE
+Via: SIP/2.0/UDP host5.example.net;branch=z9hG4bKkdjuw
+C: application/sdp
+Contact:
+  <sip:cal%6Cer@host5.example.net;%6C%72=udp%70;transport=udp>
+Content-Length: 150
+
+v=0
+o=mhandley 29739 7272939 IN IP4 192.0.2.1
+s=-
+c=IN IP4 192.0.2.1
+t=0 0
+m=audio 49217 RTP/AVP 0 12
+m=video 3227 RTP/AVP 31
+a=rtpmap:31 LPC
+`),
+			check: func(t *testing.T, sipMessage SipMessage) {
+				uri, err := sipMessage.RequestURI()
+				if err != nil || uri.User != "sips%3Auser%40example.com" || uri.Host != "example.net" {
+					t.Errorf("Request-URI %+v %v", uri, err)
+				}
+				if got := sipMessage.Headers.Get("Content-Type"); got != "application/sdp" {
+					t.Errorf("Content-Type %q", got)
+				}
+				if got := sipMessage.Headers.Get("Call-ID"); got != "esc01.239409asdfakjkn23onasd0-3234" {
+					t.Errorf("Call-ID %q", got)
+				}
+				if got := sipMessage.Headers.Get("Contact"); got != "<sip:cal%6Cer@host5.example.net;%6C%72=udp%70;transport=udp>" {
+					t.Errorf("Contact %q", got)
+				}
+				if len(sipMessage.Body) != 150 {
+					t.Errorf("body %d bytes", len(sipMessage.Body))
+				}
+			},
+		},
+		{
+			// 3.1.1.6 Message with No LWS between Display Name and <
+			name: "lwsdisp",
+			message: crlf(`OPTIONS sip:user@example.com SIP/2.0
+To: sip:user@example.com
+From: caller<sip:caller@example.com>;tag=323
+Max-Forwards: 70
+Call-ID: lwsdisp.1234abcd@funky.example.com
+CSeq: 60 OPTIONS
+Via: SIP/2.0/UDP funky.example.com;branch=z9hG4bKkdjuw
+l: 0
+
+`),
+			check: func(t *testing.T, sipMessage SipMessage) {
+				from, err := sipMessage.From()
+				if err != nil || from.DisplayName != "caller" || from.URI.User != "caller" || from.Tag() != "323" {
+					t.Errorf("From %+v %v", from, err)
+				}
+				if got := sipMessage.Headers.Get("Content-Length"); got != "0" {
+					t.Errorf("Content-Length %q", got)
+				}
+			},
+		},
+		{
+			// 3.1.1.8 Extra Trailing Octets in a UDP Datagram, the second request is discarded
+			name: "dblreq",
+			message: crlf(`REGISTER sip:example.com SIP/2.0
+To: sip:j.user@example.com
+From: sip:j.user@example.com;tag=43251j3j324
+Max-Forwards: 8
+I: dblreq.0ha0isndaksdj99sdfafnl3lk233412
+Contact: sip:j.user@host.example.com
+CSeq: 8 REGISTER
+Via: SIP/2.0/UDP 192.0.2.125;branch=z9hG4bKkdjuw23492
+Content-Length: 0
+
+
+INVITE sip:joe@example.com SIP/2.0
+t: sip:joe@example.com
+From: sip:caller@example.net;tag=141334
+Max-Forwards: 8
+Call-ID: dblreq.0ha0isnda977644900765@192.0.2.15
+CSeq: 8 INVITE
+Via: SIP/2.0/UDP 192.0.2.15;branch=z9hG4bKkdjuw380234
+Content-Type: application/sdp
+Content-Length: 150
+
+`),
+			check: func(t *testing.T, sipMessage SipMessage) {
+				if sipMessage.Method() != "REGISTER" || sipMessage.Body != "" {
+					t.Errorf("%s body %q", sipMessage.Subject, sipMessage.Body)
+				}
+				if got := sipMessage.Headers.Get("Call-ID"); got != "dblreq.0ha0isndaksdj99sdfafnl3lk233412" {
+					t.Errorf("Call-ID %q", got)
+				}
+			},
+		},
+		{
+			// 3.1.1.10 Multiple Values in Single Header Field, compact and full names
+			name: "transports",
+			message: crlf(`OPTIONS sip:user@example.com SIP/2.0
+To: sip:user@example.com
+From: <sip:caller@example.com>;tag=323
+Max-Forwards: 70
+Call-ID:  transports.kijh4akdnaqjkwendsasfdj
+Accept: application/sdp
+CSeq: 60 OPTIONS
+Via: SIP/2.0/UDP t1.example.com;branch=z9hG4bKkdjuw
+Via: SIP/2.0/SCTP t2.example.com;branch=z9hG4bKklasjdhf
+Via: SIP/2.0/TLS t3.example.com;branch=z9hG4bK2980unddj
+Via: SIP/2.0/UNKNOWN t4.example.com;branch=z9hG4bKasd0f3en
+Via: SIP/2.0/TCP t5.example.com;branch=z9hG4bK0a9idfnee
+l: 0
+
+`),
+			check: func(t *testing.T, sipMessage SipMessage) {
+				vias, err := sipMessage.Vias()
+				if err != nil || len(vias) != 5 || vias[3].Transport != "UNKNOWN" {
+					t.Errorf("Via %+v %v", vias, err)
+				}
+				if got := sipMessage.Headers.Get("Call-ID"); got != "transports.kijh4akdnaqjkwendsasfdj" {
+					t.Errorf("Call-ID %q", got)
+				}
+			},
+		},
+		{
+			// 3.1.1.13 Response with empty Reason Phrase
+			name: "noreason",
+			message: crlf(`SIP/2.0 100
+Via: SIP/2.0/UDP 192.0.2.105;branch=z9hG4bK2398ndaoe
+Call-ID: noreason.asndj203insdf99223ndf
+CSeq: 35 INVITE
+From: <sip:user@example.com>;tag=39ansfi3
+To: <sip:user@example.net>;tag=902jndnke3
+Content-Length: 0
+
+`),
+			check: func(t *testing.T, sipMessage SipMessage) {
+				if sipMessage.IsRequest() || sipMessage.StatusCode() != 100 || sipMessage.ReasonPhrase() != "" {
+					t.Errorf("status line %q", sipMessage.Subject)
+				}
+			},
+		},
+		{
+			// 3.1.1.12 Reason Phrase with UTF-8
+			name: "unreason",
+			message: crlf(`SIP/2.0 200 = 2**3 * 5**2 но сто девяносто девять - простое
+Via: SIP/2.0/UDP 192.0.2.198;branch=z9hG4bK1324923
+Call-ID: unreason.1234ksdfak3j2erwedfsASdf
+CSeq: 35 INVITE
+From: sip:user@example.com;tag=11141343
+To: sip:user@example.edu;tag=2229
+Content-Length: 154
+Content-Type: application/sdp
+Contact: <sip:user@host198.example.com>
+
+v=0
+o=mhandley 29739 7272939 IN IP4 192.0.2.198
+s=-
+c=IN IP4 192.0.2.198
+t=0 0
+m=audio 49217 RTP/AVP 0 12
+m=video 3227 RTP/AVP 31
+a=rtpmap:31 LPC
+`),
+			check: func(t *testing.T, sipMessage SipMessage) {
+				if sipMessage.StatusCode() != 200 || sipMessage.ReasonPhrase() != "= 2**3 * 5**2 но сто девяносто девять - простое" {
+					t.Errorf("status line %q", sipMessage.Subject)
+				}
+				if len(sipMessage.Body) != 154 {
+					t.Errorf("body %d bytes", len(sipMessage.Body))
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sipMessage, err := ParseSipMessage(test.message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.check(t, sipMessage)
+		})
+	}
+}
+
+// RFC 4475 3.1.2 and 3.1.3 invalid messages
+func TestParseSipMessageInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		// reason part of the Reason of ParseError
+		reason string
+	}{
+		{
+			// 3.1.2.2 Content Length Larger Than Message
+			name: "clerr",
+			message: crlf(`INVITE sip:user@example.com SIP/2.0
+Max-Forwards: 80
+To: sip:j.user@example.com
+From: sip:caller@example.net;tag=93942939o2
+Contact: <sip:caller@hungry.example.net>
+Call-ID: clerr.0ha0isndaksdjweiafasdk3
+CSeq: 8 INVITE
+Via: SIP/2.0/UDP host5.example.com;branch=z9hG4bK-39234-23523
+Content-Type: application/sdp
+Content-Length: 9999
+
+v=0
+o=mhandley 29739 7272939 IN IP4 192.0.2.155
+s=-
+c=IN IP4 192.0.2.155
+t=0 0
+m=audio 49217 RTP/AVP 0 12
+m=video 3227 RTP/AVP 31
+a=rtpmap:31 LPC
+`),
+			reason: "Content-Length 9999 is larger than body",
+		},
+		{
+			// 3.1.2.3 Negative Content-Length
+			name: "ncl",
+			message: crlf(`INVITE sip:user@example.com SIP/2.0
+Max-Forwards: 254
+To: sip:j.user@example.com
+From: sip:caller@example.net;tag=32394234
+Call-ID: ncl.0ha0isndaksdj2193423r542w35
+CSeq: 0 INVITE
+Via: SIP/2.0/UDP 192.0.2.53;branch=z9hG4bKkdjuw
+Contact: <sip:caller@example53.example.net>
+Content-Type: application/sdp
+Content-Length: -999
+
+v=0
+o=mhandley 29739 7272939 IN IP4 192.0.2.53
+s=-
+c=IN IP4 192.0.2.53
+t=0 0
+m=audio 49217 RTP/AVP 0 12
+m=video 3227 RTP/AVP 31
+a=rtpmap:31 LPC
+`),
+			reason: `invalid Content-Length "-999"`,
+		},
+		{
+			// 3.1.2.4 Request Scalar Fields with Overlarge Values
+			name: "scalar02",
+			message: crlf(`REGISTER sip:example.com SIP/2.0
+Via: SIP/2.0/TCP host129.example.com;branch=z9hG4bK342sdfoi3
+To: <sip:user@example.com>
+From: <sip:user@example.com>;tag=239232jh3
+CSeq: 36893488147419103232 REGISTER
+Call-ID: scalar02.23o0pd9vanlq3wnrlnewofjas9ui32
+Max-Forwards: 300
+Expires: 1000000000000000000000000000000000000000
+Contact: <sip:user@host129.example.com>
+  ;expires=280297596632815
+Content-Length: 0
+
+`),
+			reason: `invalid CSeq number "36893488147419103232"`,
+		},
+		{
+			// 3.1.2.5 Response Scalar Fields with Overlarge Values
+			name: "scalarlg",
+			message: crlf(`SIP/2.0 503 Service Unavailable
+Via: SIP/2.0/TCP host129.example.com;branch=z9hG4bKzzxdiwo34sw;received=192.0.2.129
+To: <sip:user@example.com>
+From: <sip:other@example.net>;tag=2easdjfejw
+CSeq: 9292394834772304023312 OPTIONS
+Call-ID: scalarlg.noase0of0234hn2qofoaf0232aewf2394r
+Retry-After: 949302838503028349304023988
+Warning: 1812 overture "In Progress"
+Content-Length: 0
+
+`),
+			reason: `invalid CSeq number "9292394834772304023312"`,
+		},
+		{
+			// 3.1.2.8 Request-URI Enclosed in <>
+			name: "ltgtruri",
+			message: crlf(`INVITE <sip:user@example.com> SIP/2.0
+To: sip:user@example.com
+From: sip:caller@example.net;tag=39291
+Max-Forwards: 23
+Call-ID: ltgtruri.1@192.0.2.5
+CSeq: 1 INVITE
+Via: SIP/2.0/UDP 192.0.2.5
+Contact: <sip:caller@host5.example.net>
+Content-Type: application/sdp
+Content-Length: 0
+
+`),
+			reason: `invalid Request-URI "<sip:user@example.com>"`,
+		},
+		{
+			// 3.1.2.9 Malformed SIP Request-URI (embedded LWS)
+			name: "lwsruri",
+			message: crlf(`INVITE sip:user@example.com; lr SIP/2.0
+To: sip:user@example.com;tag=3xfe-9921883-z9f
+From: sip:caller@example.net;tag=231413434
+Max-Forwards: 5
+Call-ID: lwsruri.asdfasdoeoi2323-asdfwrn23-asd834rk423
+CSeq: 2130706432 INVITE
+Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bKkdjuw2395
+Contact: <sip:caller@host1.example.net>
+Content-Length: 0
+
+`),
+			reason: "invalid request line",
+		},
+		{
+			// 3.1.2.10 Multiple SP Separating Request-Line Elements
+			name: "lwsstart",
+			message: crlf(`INVITE  sip:user@example.com  SIP/2.0
+Max-Forwards: 8
+To: sip:user@example.com
+From: sip:caller@example.net;tag=8814
+Call-ID: lwsstart.dfknq234oi243099adsdfnawe3@example.com
+CSeq: 1893884 INVITE
+Via: SIP/2.0/UDP host1.example.com;branch=z9hG4bKkdjuw3923
+Contact: <sip:caller@host1.example.net>
+Content-Length: 0
+
+`),
+			reason: "invalid request line",
+		},
+		{
+			// 3.1.2.12 Missing Required Header Fields
+			name: "insuf",
+			message: crlf(`INVITE sip:user@example.com SIP/2.0
+CSeq: 193942 INVITE
+Via: SIP/2.0/UDP 192.0.2.95;branch=z9hG4bKkdj.insuf
+Content-Type: application/sdp
+l: 0
+
+`),
+			reason: "header From is missing",
+		},
+		{
+			// 3.1.2.16 Multiple Content-Length Values
+			name: "mcl01",
+			message: crlf(`OPTIONS sip:user@example.com SIP/2.0
+Via: SIP/2.0/UDP host5.example.net;branch=z9hG4bK293423
+To: sip:user@example.com
+From: sip:other@example.net;tag=3923942
+Call-ID: mcl01.fhn2323orihawfdoa3o4r52o3irsdf
+CSeq: 15932 OPTIONS
+Content-Length: 13
+Max-Forwards: 60
+Content-Length: 5
+Content-Type: text/plain
+
+There's no way to know how many octets are supposed to be here.
+`),
+			reason: "header Content-Length is repeated",
+		},
+		{
+			// 3.1.2.14 Unknown Protocol Version
+			name: "badvers",
+			message: crlf(`OPTIONS sip:t.watson@example.org SIP/7.0
+Via:     SIP/7.0/UDP c.example.com;branch=z9hG4bKkdjuw
+Max-Forwards:     70
+From:    A. Bell <sip:a.g.bell@example.com>;tag=qweoiqpe
+To:      T. Watson <sip:t.watson@example.org>
+Call-ID: badvers.31417@c.example.com
+CSeq:    1 OPTIONS
+l: 0
+
+`),
+			reason: "unsupported version SIP/7.0",
+		},
+		{
+			// 3.1.2.17 Response with overlarge status code
+			name: "bigcode",
+			message: crlf(`SIP/2.0 4294967301 better not break the receiver
+Via: SIP/2.0/UDP 192.0.2.105;branch=z9hG4bK2398ndaoe
+Call-ID: bigcode.asdof3uj203asdnf3429uasdhfas3ehjasdfas9i
+CSeq: 353494 INVITE
+From: <sip:user@example.com>;tag=39ansfi3
+To: <sip:user@example.edu>;tag=902jndnke3
+Content-Length: 0
+Contact: <sip:user@host105.example.com>
+
+`),
+			reason: `invalid status code "4294967301"`,
+		},
+		{
+			// 3.1.3.1 Request with Mismatched Method in CSeq
+			name: "mismatch01",
+			message: crlf(`OPTIONS sip:user@example.com SIP/2.0
+To: sip:j.user@example.com
+From: sip:caller@example.net;tag=34525
+Max-Forwards: 6
+Call-ID: mismatch01.dj0234sxdfl3
+CSeq: 8 INVITE
+Via: SIP/2.0/UDP host.example.com;branch=z9hG4bKkdjuw
+l: 0
+
+`),
+			reason: "CSeq method INVITE doesn't match request method OPTIONS",
+		},
+		{
+			name:    "no empty line",
+			message: "OPTIONS sip:user@example.com SIP/2.0\r\nTo: sip:user@example.com\r\n",
+			reason:  "no empty line after headers",
+		},
+		{
+			name: "no colon",
+			message: crlf(`OPTIONS sip:user@example.com SIP/2.0
+Garbage
+
+`),
+			reason: `no colon in header "Garbage"`,
+		},
+		{
+			name: "space in header name",
+			message: crlf(`OPTIONS sip:user@example.com SIP/2.0
+To sip:user@example.com
+
+`),
+			reason: `invalid header name "To sip"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseSipMessage(test.message)
+			var parseError *ParseError
+			if !errors.As(err, &parseError) {
+				t.Fatalf("expected ParseError, got %v", err)
+			}
+			if !strings.HasPrefix(parseError.Reason, test.reason) {
+				t.Errorf("reason %q, expected %q", parseError.Reason, test.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package softphone
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrNotPracked the previous reliable provisional response isn't acknowledged yet
+var ErrNotPracked = errors.New("reliable provisional response is not acknowledged")
+
+// ErrPrackTimeout INVITE was rejected with 504 because PRACK for reliable provisional response didn't come in time
+var ErrPrackTimeout = errors.New("reliable provisional response is not acknowledged in time")
+
+// prackTimeoutHangup hangup reason of the call whose INVITE is rejected with 504 for missing PRACK
+var prackTimeoutHangup = HangupReason{Method: "INVITE", Reason: `SIP;cause=504;text="Server Time-out"`}
+
+// RespondReliably send provisional response reliably, RFC 3262: it is retransmitted until PRACK is received.
+// The request must support 100rel.
+func (t *ServerTransaction) RespondReliably(response SipMessage) error {
+	t.addLocalTag(&response)
+	t.mu.Lock()
+	if t.answered {
+		t.mu.Unlock()
+		return ErrAlreadyAnswered
+	}
+	if t.pracked != nil {
+		select {
+		case <-t.pracked:
+		default:
+			t.mu.Unlock()
+			return ErrNotPracked
+		}
+	}
+	if t.rseq == 0 {
+		// random initial RSeq below 2**31, RFC 3262 3
+		t.rseq = uuid.New().ID()>>1 + 1
+	} else {
+		t.rseq++
+	}
+	response.Headers.Set("Require", "100rel")
+	response.Headers.Set("RSeq", fmt.Sprint(t.rseq))
+	pracked := make(chan struct{})
+	t.pracked = pracked
+	t.lastResponse = &response
+	t.mu.Unlock()
+
+	if err := t.s.Send(response); err != nil {
+		return err
+	}
+	go t.retransmitReliable(response, pracked)
+	return nil
+}
+
+// retransmitReliable retransmit reliable provisional response with doubling interval until PRACK or the final
+// response, the request is rejected with 504 and its call is ended when PRACK isn't received in 64*T1
+func (t *ServerTransaction) retransmitReliable(response SipMessage, pracked chan struct{}) {
+	deadline := time.NewTimer(transactionTimeout)
+	defer deadline.Stop()
+	interval := T1
+	for {
+		timer := time.NewTimer(interval)
+		select {
+		case <-pracked:
+			timer.Stop()
+			return
+		case <-deadline.C:
+			timer.Stop()
+			t.mu.Lock()
+			t.prackTimedOut = !t.answered
+			call := t.call
+			t.mu.Unlock()
+			if err := t.Reply(504, "Server Time-out"); err == ErrAlreadyAnswered {
+				// answered or canceled meanwhile
+				t.mu.Lock()
+				t.prackTimedOut = false
+				t.mu.Unlock()
+				return
+			} else if err != nil {
+				log.Println(err)
+			}
+			if call != nil {
+				call.terminate(prackTimeoutHangup)
+			}
+			return
+		case <-timer.C:
+		}
+		if t.Answered() {
+			return
+		}
+		if err := t.s.Send(response); err != nil {
+			log.Println(err)
+		}
+		interval *= 2
+	}
+}
+
+// handlePrack acknowledge reliable provisional response of INVITE transaction matched by Call-ID and RAck
+func (s *Softphone) handlePrack(t *ServerTransaction) error {
+	request := t.Request()
+	rack, err := ParseRAck(request.Headers.Get("RAck"))
+	if err != nil {
+		return t.Reply(400, "Bad Request")
+	}
+	s.mu.Lock()
+	var invite *ServerTransaction
+	for _, transaction := range s.serverTransactions {
+		cseq, err := transaction.request.CSeq()
+		if err != nil || cseq.Method != rack.Method || cseq.Seq != rack.CSeq {
+			continue
+		}
+		if transaction.request.Headers.Get("Call-ID") == request.Headers.Get("Call-ID") {
+			invite = transaction
+			break
+		}
+	}
+	s.mu.Unlock()
+	if invite == nil {
+		return t.Reply(481, "Call/Transaction Does Not Exist")
+	}
+
+	invite.mu.Lock()
+	pracked := invite.pracked
+	matched := pracked != nil && invite.rseq == rack.RSeq
+	invite.mu.Unlock()
+	if !matched {
+		return t.Reply(481, "Call/Transaction Does Not Exist")
+	}
+	select {
+	case <-pracked:
+	default:
+		close(pracked)
+	}
+	return t.Reply(200, "OK")
+}
+
+// newPrack PRACK for reliable provisional response to INVITE sent by us. c.mu must be held.
+func (c *Call) newPrack() SipMessage {
+	cseq, _ := c.invite.CSeq()
+	prack := c.newRequest("PRACK")
+	prack.Headers.Add("RAck", RAck{RSeq: c.rseq, CSeq: cseq.Seq, Method: cseq.Method}.String())
+	return prack
+}
+
+// sendPrack send PRACK, failed PRACK doesn't end the call
+func (c *Call) sendPrack(prack SipMessage) {
+	response, err := c.requestWithAuth(prack)
+	if err == nil && response.StatusCode() >= 300 {
+		err = newResponseError(response)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,38 @@
+package softphone
+
+import "encoding/xml"
+
+// Presence presence document of "presence" event package, application/pidf+xml, RFC 3863
+type Presence struct {
+	Entity string          `xml:"entity,attr"`
+	Tuples []PresenceTuple `xml:"tuple"`
+	Notes  []string        `xml:"note"`
+}
+
+// PresenceTuple presence of one device or service of the presentity
+type PresenceTuple struct {
+	ID string `xml:"id,attr"`
+	// Basic "open" or "closed"
+	Basic   string   `xml:"status>basic"`
+	Contact string   `xml:"contact"`
+	Notes   []string `xml:"note"`
+}
+
+// ParsePresence parse PIDF document
+func ParsePresence(body string) (Presence, error) {
+	var presence Presence
+	if err := xml.Unmarshal([]byte(body), &presence); err != nil {
+		return Presence{}, &ParseError{Reason: "invalid PIDF: " + err.Error()}
+	}
+	return presence, nil
+}
+
+// Open reports whether any tuple of the presentity is open
+func (presence Presence) Open() bool {
+	for _, tuple := range presence.Tuples {
+		if tuple.Basic == "open" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package softphone
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePresence(t *testing.T) {
+	// RFC 3863 6, with a second closed tuple
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+<presence xmlns="urn:ietf:params:xml:ns:pidf" xmlns:im="urn:ietf:params:xml:ns:pidf:im" entity="pres:someone@example.com">
+  <tuple id="bs35r9">
+    <status><basic>open</basic><im:im>busy</im:im></status>
+    <contact priority="0.8">im:someone@mobilecarrier.net</contact>
+    <note xml:lang="en">Don't Disturb Please!</note>
+    <timestamp>2001-10-27T16:49:29Z</timestamp>
+  </tuple>
+  <tuple id="eg92n8">
+    <status><basic>closed</basic></status>
+    <contact>mailto:someone@example.com</contact>
+  </tuple>
+  <note>I'll be in Tokyo next week</note>
+</presence>`
+	presence, err := ParsePresence(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Presence{
+		Entity: "pres:someone@example.com",
+		Tuples: []PresenceTuple{
+			{ID: "bs35r9", Basic: "open", Contact: "im:someone@mobilecarrier.net", Notes: []string{"Don't Disturb Please!"}},
+			{ID: "eg92n8", Basic: "closed", Contact: "mailto:someone@example.com"},
+		},
+		Notes: []string{"I'll be in Tokyo next week"},
+	}
+	if !reflect.DeepEqual(presence, expected) {
+		t.Errorf("presence\n%+v\nexpected\n%+v", presence, expected)
+	}
+	if !presence.Open() {
+		t.Error("presence isn't open")
+	}
+}
+
+func TestPresenceClosed(t *testing.T) {
+	presence, err := ParsePresence(`<presence xmlns="urn:ietf:params:xml:ns:pidf" entity="sip:bob@example.com">
+  <tuple id="1"><status><basic>closed</basic></status></tuple>
+</presence>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if presence.Open() {
+		t.Error("closed presence is open")
+	}
+}
+
+func TestParsePresenceInvalid(t *testing.T) {
+	for _, body := range []string{"", "presence", `<presence><tuple>`} {
+		if presence, err := ParsePresence(body); err == nil {
+			t.Errorf("%q parsed as %+v", body, presence)
+		} else if _, ok := err.(*ParseError); !ok {
+			t.Errorf("%q error %v isn't ParseError", body, err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package softphone
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+)
+
+// maxRedirects number of 3xx responses followed by an outgoing call, more of them are a redirection loop
+const maxRedirects = 5
+
+// redirection targets of 3xx responses to outgoing call, RFC 3261 8.1.3.4
+type redirection struct {
+	redirects int
+	targets   []redirectTarget
+	// tried Request-URIs of sent and queued INVITEs, a target is tried once
+	tried map[string]bool
+}
+
+// redirectTarget Contact of 3xx response
+type redirectTarget struct {
+	contact  NameAddr
+	response SipMessage
+}
+
+func newRedirection(request SipMessage) *redirection {
+	r := &redirection{tried: map[string]bool{}}
+	if uri, err := request.RequestURI(); err == nil {
+		r.tried[redirectKey(uri)] = true
+	}
+	return r
+}
+
+// redirectKey target URI without headers
+func redirectKey(uri URI) string {
+	uri.Headers = nil
+	return uri.String()
+}
+
+// isRedirect 3xx response which Contact targets are followed
+func isRedirect(code int) bool {
+	return code == 300 || code == 301 || code == 302
+}
+
+// qValue q parameter of Contact, 1 if it is missing or invalid
+func qValue(contact NameAddr) float64 {
+	value, ok := contact.Params.Get("q")
+	if !ok {
+		return 1
+	}
+	q, err := strconv.ParseFloat(value, 64)
+	if err != nil || q < 0 || q > 1 {
+		return 1
+	}
+	return q
+}
+
+// redirect INVITE to the next target accepted by OnRedirect after the final response, false if the call isn't redirected.
+// Targets of 3xx go first in order of q-value, the other targets are tried when the target fails with 3xx-5xx.
+func (c *Call) redirect(r *redirection, request, response SipMessage) (SipMessage, bool, error) {
+	code := response.StatusCode()
+	if isRedirect(code) {
+		if r.redirects >= maxRedirects {
+			return SipMessage{}, false, nil
+		}
+		r.redirects++
+		contacts, err := response.Contacts()
+		if err != nil {
+			return SipMessage{}, false, err
+		}
+		sort.SliceStable(contacts, func(i, j int) bool {
+			return qValue(contacts[i]) > qValue(contacts[j])
+		})
+		targets := []redirectTarget{}
+		for _, contact := range contacts {
+			key := redirectKey(contact.URI)
+			if r.tried[key] {
+				continue
+			}
+			r.tried[key] = true
+			targets = append(targets, redirectTarget{contact: contact, response: response})
+		}
+		r.targets = append(targets, r.targets...)
+	} else if code >= 600 {
+		// global failure, RFC 3261 21.6
+		return SipMessage{}, false, nil
+	}
+
+	for len(r.targets) > 0 {
+		target := r.targets[0]
+		r.targets = r.targets[1:]
+		if c.s.OnRedirect != nil && !c.s.OnRedirect(c, target.response, target.contact) {
+			continue
+		}
+		return c.redirectInvite(request, target.contact.URI), true, nil
+	}
+	return SipMessage{}, false, nil
+}
+
+// redirectInvite INVITE to the target with a new branch and the next CSeq in the same Call-ID, To has no tag.
+// The offer is the same unless early media of the previous target was set up.
+func (c *Call) redirectInvite(request SipMessage, target URI) SipMessage {
+	target.Headers = nil
+	c.mu.Lock()
+	c.dialog.RemoteTag = ""
+	c.dialog.RemoteTarget = target
+	c.dialog.RouteSet = nil
+	early := c.earlySDP != ""
+	c.earlySDP = ""
+	c.earlyTag = ""
+	c.mu.Unlock()
+
+	body := request.Body
+	if early {
+		// the PeerConnection is bound to the early answer of the previous target
+		body = c.renewPeerConnection()
+	}
+	redirected := SipMessage{
+		Subject: fmt.Sprintf("INVITE %s SIP/2.0", target),
+		Headers: append(Headers{}, request.Headers...),
+		Body:    body,
+	}
+	// credentials are for the previous Request-URI
+	redirected.Headers.Del("Authorization")
+	redirected.Headers.Del("Proxy-Authorization")
+	redirected.Headers.Set("Via", c.s.via().String())
+	redirected.IncreaseSeq()
+	return redirected
+}
+
+// renewPeerConnection replace the PeerConnection of outgoing call by a new one, returns its offer
+func (c *Call) renewPeerConnection() string {
+	peerConnection := c.s.newOfferingPeerConnection(c.audioTrack)
+	c.handleTracks(peerConnection)
+	c.mu.Lock()
+	previous := c.peerConnection
+	if c.ended {
+		previous = peerConnection
+	} else {
+		c.peerConnection = peerConnection
+	}
+	c.mu.Unlock()
+	if err := previous.Close(); err != nil {
+		log.Println(err)
+	}
+	return peerConnection.LocalDescription().SDP
+}
@@ -0,0 +1,242 @@
+package softphone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// ErrTransferInProgress the call has REFER waiting for the result
+var ErrTransferInProgress = errors.New("transfer is in progress")
+
+// targetURI SIP URI of extension, SIP URI or name-addr
+func (s *Softphone) targetURI(target string) (URI, error) {
+	if strings.Contains(target, ":") {
+		nameAddr, err := ParseNameAddr(target)
+		if err != nil {
+			return URI{}, err
+		}
+		return nameAddr.URI, nil
+	}
+	return URI{Scheme: "sip", User: target, Host: s.options.Domain}, nil
+}
+
+// Transfer blind transfer of the established call to target, extension or SIP URI, by REFER, RFC 3515.
+// Returns when the other side reports the result of the transfer: the call is hung up if the target answered,
+// otherwise the call stays and the failure is returned as ResponseError.
+func (c *Call) Transfer(target string) error {
+	uri, err := c.s.targetURI(target)
+	if err != nil {
+		return err
+	}
+	return c.refer(NameAddr{URI: uri})
+}
+
+// AttendedTransfer transfer of the established call to the other side of other established call, RFC 5589 7.
+// REFER asks the other side to call the target with Replaces, so the target swaps other for the new call.
+// Returns like Transfer, other is ended by the target when the transfer succeeds.
+func (c *Call) AttendedTransfer(other *Call) error {
+	other.mu.Lock()
+	established := other.established
+	target := other.dialog.RemoteURI.URI
+	other.mu.Unlock()
+	if !established {
+		return ErrCallNotEstablished
+	}
+	target.Headers = Params{{"Replaces", url.QueryEscape(other.replaces().String())}}
+	return c.refer(NameAddr{URI: target})
+}
+
+// refer send REFER to referTo and wait for the final status in NOTIFY
+func (c *Call) refer(referTo NameAddr) error {
+	c.mu.Lock()
+	if !c.established {
+		c.mu.Unlock()
+		return ErrCallNotEstablished
+	}
+	if c.transfer != nil {
+		c.mu.Unlock()
+		return ErrTransferInProgress
+	}
+	request := c.newRequest("REFER")
+	request.Headers.Add("Refer-To", referTo.String())
+	request.Headers.Add("Referred-By", NameAddr{URI: c.s.addressOfRecord()}.String())
+	transfer := make(chan SipMessage, 16)
+	c.transfer = transfer
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.transfer = nil
+		c.mu.Unlock()
+	}()
+
+	response, err := c.requestWithAuth(request)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode() >= 300 {
+		return newResponseError(response)
+	}
+
+	for {
+		select {
+		case status := <-transfer:
+			code := status.StatusCode()
+			if c.s.OnTransferProgress != nil {
+				c.s.OnTransferProgress(c, code, status.ReasonPhrase())
+			}
+			if code < 200 {
+				continue
+			}
+			if code >= 300 {
+				return &ResponseError{Method: "INVITE", StatusCode: code, ReasonPhrase: status.ReasonPhrase()}
+			}
+			// the transferee is connected to the target, RFC 5589 6.1
+			return c.Hangup()
+		case <-c.done:
+			return ErrCallNotEstablished
+		}
+	}
+}
+
+// handleNotify answer NOTIFY, the progress of REFER sent by us goes to its call, other events to their subscription
+func (s *Softphone) handleNotify(t *ServerTransaction) error {
+	request := t.Request()
+	event := strings.TrimSpace(strings.SplitN(request.Headers.Get("Event"), ";", 2)[0])
+	if !strings.EqualFold(event, "refer") {
+		// message waiting indication can be sent without subscription, RFC 3842 3
+		if to, err := request.To(); err == nil && to.Tag() == "" && strings.EqualFold(event, "message-summary") {
+			return s.handleUnsolicitedMessageSummary(t)
+		}
+		return s.handleSubscriptionNotify(t, event)
+	}
+	call := s.findCall(request)
+	if call == nil {
+		return t.Reply(481, "Call/Transaction Does Not Exist")
+	}
+	if err := t.Reply(200, "OK"); err != nil {
+		return err
+	}
+	// message/sipfrag body starts with the status line of the response to INVITE
+	status := SipMessage{Subject: strings.TrimSpace(strings.SplitN(request.Body, "\n", 2)[0])}
+	if status.IsRequest() || status.StatusCode() == 0 {
+		return nil
+	}
+	call.mu.Lock()
+	transfer := call.transfer
+	call.mu.Unlock()
+	if transfer != nil {
+		select {
+		case transfer <- status:
+		default:
+		}
+	}
+	return nil
+}
+
+// handleRefer accept REFER of the other side of the call and call the Refer-To target
+func (s *Softphone) handleRefer(t *ServerTransaction) error {
+	request := t.Request()
+	call := s.findCall(request)
+	if call == nil {
+		return t.Reply(481, "Call/Transaction Does Not Exist")
+	}
+	referTo, err := ParseNameAddr(request.Headers.Get("Refer-To"))
+	if err != nil || !request.Headers.Has("Refer-To") {
+		return t.Reply(400, "Bad Request")
+	}
+	cseq, err := request.CSeq()
+	if err != nil {
+		return t.Reply(400, "Bad Request")
+	}
+	if err := t.Reply(202, "Accepted"); err != nil {
+		return err
+	}
+	go call.referred(referTo.URI, cseq.Seq)
+	return nil
+}
+
+// referred call target for REFER with CSeq number id, the progress is reported to the referrer by NOTIFY
+// with message/sipfrag, RFC 3515 2.4.4. Replaces embedded in the target goes to the INVITE.
+// The call to the target is passed to OnTransfer when it is answered.
+func (c *Call) referred(target URI, id uint32) {
+	notifications := make(chan string, 16)
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for status := range notifications {
+			c.notifyRefer(id, status)
+		}
+	}()
+	defer func() {
+		close(notifications)
+		<-sent
+	}()
+	notifications <- "SIP/2.0 100 Trying"
+
+	to := target
+	to.Headers = nil
+	replaces, hasReplaces, err := embeddedReplaces(target)
+	if err != nil {
+		log.Println("refer:", err)
+		notifications <- "SIP/2.0 400 Bad Request"
+		return
+	}
+	newCall, err := c.s.dial(context.Background(), to, to, func(call *Call, request *SipMessage) {
+		if hasReplaces {
+			request.Headers.Add("Replaces", replaces.String())
+			request.Headers.Add("Require", "replaces")
+		}
+		call.progress = func(response SipMessage) {
+			if response.StatusCode() > 100 {
+				notifications <- response.Subject
+			}
+		}
+	})
+	if err == nil {
+		err = newCall.Wait()
+	}
+	if err != nil {
+		log.Println("refer:", err)
+		if e, ok := err.(*ResponseError); ok {
+			notifications <- fmt.Sprintf("SIP/2.0 %d %s", e.StatusCode, e.ReasonPhrase)
+		} else {
+			notifications <- "SIP/2.0 503 Service Unavailable"
+		}
+		return
+	}
+	notifications <- "SIP/2.0 200 OK"
+	if c.s.OnTransfer != nil {
+		c.s.OnTransfer(c, newCall)
+	}
+}
+
+// notifyRefer send NOTIFY with the status of the call made for REFER, final status terminates the subscription
+func (c *Call) notifyRefer(id uint32, status string) {
+	c.mu.Lock()
+	if c.ended {
+		c.mu.Unlock()
+		return
+	}
+	request := c.newRequest("NOTIFY")
+	c.mu.Unlock()
+
+	state := "active;expires=60"
+	if (SipMessage{Subject: status}).StatusCode() >= 200 {
+		state = "terminated;reason=noresource"
+	}
+	request.Headers.Add("Event", fmt.Sprintf("refer;id=%d", id))
+	request.Headers.Add("Subscription-State", state)
+	request.Headers.Add("Content-Type", "message/sipfrag;version=2.0")
+	request.Body = status + "\r\n"
+	response, err := c.requestWithAuth(request)
+	if err == nil && response.StatusCode() >= 300 {
+		err = newResponseError(response)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,336 @@
+package softphone
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// ErrRequestPending the call has re-INVITE in progress
+var ErrRequestPending = errors.New("re-INVITE is in progress")
+
+// ErrNoAnswer ACK for our offer in 2xx to re-INVITE has no answer
+var ErrNoAnswer = errors.New("ACK has no answer")
+
+// glareRetries how many times re-INVITE is repeated after 491 Request Pending
+const glareRetries = 3
+
+// reinvite send re-INVITE with a new offer of the PeerConnection, it is repeated after 491 Request Pending
+// with random delay, RFC 3261 14.1
+func (c *Call) reinvite() error {
+	for i := 0; ; i++ {
+		err := c.sendOffer()
+		if e, ok := err.(*ResponseError); !ok || e.StatusCode != 491 || i == glareRetries {
+			return err
+		}
+		time.Sleep(c.glareDelay())
+	}
+}
+
+// glareDelay wait time before repeating re-INVITE: 2.1-4 s for the owner of Call-ID, 0-2 s for the other side
+func (c *Call) glareDelay() time.Duration {
+	if c.outgoing {
+		return 2100*time.Millisecond + time.Duration(rand.Intn(190))*10*time.Millisecond
+	}
+	return time.Duration(rand.Intn(200)) * 10 * time.Millisecond
+}
+
+// sendOffer send re-INVITE with a new offer of the PeerConnection
+func (c *Call) sendOffer() error {
+	c.mu.Lock()
+	if !c.established {
+		c.mu.Unlock()
+		return ErrCallNotEstablished
+	}
+	if c.offering {
+		c.mu.Unlock()
+		return ErrRequestPending
+	}
+	c.offering = true
+	direction := c.localDirection()
+	peerConnection := c.peerConnection
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.offering = false
+		c.mu.Unlock()
+	}()
+
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	if err := peerConnection.SetLocalDescription(offer); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	request := c.newRequest("INVITE")
+	c.addSessionHeaders(&request)
+	c.mu.Unlock()
+	request.Headers.Add("Content-Type", "application/sdp")
+	request.Body = setSDPDirection(peerConnection.LocalDescription().SDP, direction)
+
+	response, err := c.inviteInDialog(request)
+	if err == nil && response.StatusCode() >= 300 {
+		err = newResponseError(response)
+	}
+	if err != nil {
+		// the session stays as it was, RFC 3261 14.1
+		c.withdrawOffer()
+		return err
+	}
+	c.sessionTimerFromResponse(response)
+	return peerConnection.SetRemoteDescription(remoteDescription(webrtc.SDPTypeAnswer, response.Body))
+}
+
+// inviteInDialog send INVITE inside the dialog and ACK 2xx, one authentication challenge is answered.
+// Retransmitted 2xx are ACKed again.
+func (c *Call) inviteInDialog(request SipMessage) (SipMessage, error) {
+	var mu sync.Mutex
+	var ack *SipMessage
+	handler := func(response SipMessage) {
+		mu.Lock()
+		defer mu.Unlock()
+		if code := response.StatusCode(); code >= 200 && code < 300 && ack != nil {
+			if err := c.s.Send(*ack); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+
+	authorized := false
+	for {
+		t, err := c.s.Request(request, handler)
+		if err != nil {
+			return SipMessage{}, err
+		}
+		response, err := t.Response()
+		if err != nil {
+			return response, err
+		}
+		code := response.StatusCode()
+		if (code == 401 || code == 407) && !authorized {
+			authorized = true
+			if request, err = c.s.authorize(request, response); err != nil {
+				return response, err
+			}
+			cseq, err := request.CSeq()
+			if err != nil {
+				return response, err
+			}
+			c.mu.Lock()
+			if cseq.Seq > c.dialog.LocalSeq {
+				c.dialog.LocalSeq = cseq.Seq
+			}
+			c.mu.Unlock()
+			continue
+		}
+		if code >= 300 {
+			return response, nil
+		}
+
+		cseq, err := request.CSeq()
+		if err != nil {
+			return response, err
+		}
+		c.mu.Lock()
+		c.updateRemoteTarget(response)
+		a := c.newRequest("ACK")
+		c.mu.Unlock()
+		a.Headers.Set("CSeq", CSeq{Seq: cseq.Seq, Method: "ACK"}.String())
+		mu.Lock()
+		ack = &a
+		mu.Unlock()
+		return response, c.s.Send(a)
+	}
+}
+
+// handleOffer find the call of INVITE or UPDATE received inside a dialog and pass the request to it
+func (s *Softphone) handleOffer(t *ServerTransaction) {
+	call := s.findCall(t.Request())
+	if call == nil {
+		if err := t.Reply(481, "Call/Transaction Does Not Exist"); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	if err := call.handleOffer(t); err != nil {
+		log.Println(err)
+	}
+}
+
+// handleOffer answer re-INVITE or UPDATE, RFC 3261 14.2 and RFC 3311: the offer is applied to the PeerConnection
+// and the answer is returned in 200 OK. Re-INVITE without offer gets our offer in 200 OK and the answer in ACK,
+// UPDATE without offer only refreshes the session.
+func (c *Call) handleOffer(t *ServerTransaction) error {
+	request := t.Request()
+	cseq, err := request.CSeq()
+	if err != nil {
+		return t.Reply(400, "Bad Request")
+	}
+	if rejected, err := rejectSmallInterval(t); rejected {
+		return err
+	}
+	c.mu.Lock()
+	if cseq.Seq <= c.dialog.RemoteSeq {
+		c.mu.Unlock()
+		return t.Reply(500, "Server Internal Error")
+	}
+	c.dialog.RemoteSeq = cseq.Seq
+	if c.offering {
+		c.mu.Unlock()
+		return t.Reply(491, "Request Pending")
+	}
+	c.offering = true
+	c.updateRemoteTarget(request)
+	peerConnection := c.peerConnection
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.offering = false
+		c.mu.Unlock()
+	}()
+
+	response := NewResponse(request, 200, "OK")
+	response.Headers.Add("Contact", c.s.contact().String())
+	response.Headers.Add("Allow", allowMethods)
+	startSessionTimer := c.sessionTimerForRequest(request, &response)
+	if !hasSDP(request) {
+		if request.Method() == "UPDATE" {
+			if err := t.Respond(response); err != nil {
+				return err
+			}
+			startSessionTimer()
+			return nil
+		}
+		if err := c.offerInResponse(t, response); err != nil {
+			return err
+		}
+		startSessionTimer()
+		return nil
+	}
+
+	if err := peerConnection.SetRemoteDescription(remoteDescription(webrtc.SDPTypeOffer, request.Body)); err != nil {
+		log.Println(err)
+		return t.Reply(488, "Not Acceptable Here")
+	}
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+	if err := peerConnection.SetLocalDescription(answer); err != nil {
+		return err
+	}
+
+	remoteDirection := sdpDirection(request.Body)
+	c.mu.Lock()
+	direction := answerDirection(c.localHold, remoteDirection)
+	c.mu.Unlock()
+
+	response.Headers.Add("Content-Type", "application/sdp")
+	response.Body = setSDPDirection(peerConnection.LocalDescription().SDP, direction)
+	if err := t.Respond(response); err != nil {
+		return err
+	}
+	startSessionTimer()
+	c.setRemoteHold(remoteDirection == webrtc.RTPTransceiverDirectionSendonly || remoteDirection == webrtc.RTPTransceiverDirectionInactive)
+	return nil
+}
+
+// offerInResponse answer re-INVITE without offer: our offer goes in 200 OK, the answer comes in ACK, RFC 3261 14.2
+func (c *Call) offerInResponse(t *ServerTransaction, response SipMessage) error {
+	peerConnection := c.PeerConnection()
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	if err := peerConnection.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	direction := c.localDirection()
+	c.mu.Unlock()
+
+	response.Headers.Add("Content-Type", "application/sdp")
+	response.Body = setSDPDirection(peerConnection.LocalDescription().SDP, direction)
+	if err := t.Respond(response); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(transactionTimeout)
+	defer timer.Stop()
+	select {
+	case <-t.Acked():
+	case <-timer.C:
+	case <-c.done:
+		return nil
+	}
+	ack := t.ackRequest()
+	if !hasSDP(ack) {
+		c.withdrawOffer()
+		// the session can't continue without the answer, RFC 3261 14.2
+		if err := c.Hangup(); err != nil {
+			log.Println(err)
+		}
+		return ErrNoAnswer
+	}
+	if err := peerConnection.SetRemoteDescription(remoteDescription(webrtc.SDPTypeAnswer, ack.Body)); err != nil {
+		return err
+	}
+	if direction == webrtc.RTPTransceiverDirectionSendrecv {
+		remoteDirection := sdpDirection(ack.Body)
+		c.setRemoteHold(remoteDirection == webrtc.RTPTransceiverDirectionSendonly || remoteDirection == webrtc.RTPTransceiverDirectionInactive)
+	}
+	return nil
+}
+
+// withdrawOffer return the PeerConnection to stable state after our offer is rejected. Pion has no rollback
+// of local offer, so the current remote SDP is applied again as the answer.
+func (c *Call) withdrawOffer() {
+	peerConnection := c.PeerConnection()
+	current := peerConnection.CurrentRemoteDescription()
+	if current == nil {
+		return
+	}
+	if err := peerConnection.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: current.SDP}); err != nil {
+		log.Println(err)
+	}
+}
+
+// setRemoteHold remember hold state of the other side and report its change to OnHold
+func (c *Call) setRemoteHold(hold bool) {
+	c.mu.Lock()
+	changed := hold != c.remoteHold
+	c.remoteHold = hold
+	c.mu.Unlock()
+	c.holdMedia()
+	if changed && c.s.OnHold != nil {
+		c.s.OnHold(c, hold)
+	}
+}
+
+// updateRemoteTarget take the remote target from Contact of target refresh request or its response. c.mu must be held.
+func (c *Call) updateRemoteTarget(sipMessage SipMessage) {
+	contacts, err := sipMessage.Contacts()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(contacts) > 0 {
+		c.dialog.RemoteTarget = contacts[0].URI
+	}
+}
+
+// remoteDescription remote SDP for pion. Pion always gets sendrecv, so its transceiver is kept on hold,
+// the call stops sending by holdMedia.
+func remoteDescription(sdpType webrtc.SDPType, body string) webrtc.SessionDescription {
+	return webrtc.SessionDescription{
+		Type: sdpType,
+		SDP:  setSDPDirection(patchFreeSwitchSDP(body), webrtc.RTPTransceiverDirectionSendrecv),
+	}
+}
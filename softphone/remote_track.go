@@ -0,0 +1,112 @@
+package softphone
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// receiveMTU size of buffer for received RTP packet
+const receiveMTU = 1460
+
+// RemoteTrack remote audio track of a call. RFC 4733 telephone-events are not returned by Read and ReadRTP,
+// they are decoded while the track is read and the digits are passed to OnDTMF.
+type RemoteTrack struct {
+	*webrtc.TrackRemote
+	call *Call
+
+	// the last event, its end packets are sent three times, RFC 4733 2.5.1.4
+	hasEvent       bool
+	eventEnded     bool
+	eventTimestamp uint32
+	eventDigit     rune
+	eventUnits     uint16
+	eventClockRate uint32
+}
+
+func newRemoteTrack(track *webrtc.TrackRemote, call *Call) *RemoteTrack {
+	return &RemoteTrack{TrackRemote: track, call: call}
+}
+
+// Codec codec of the last read packet. Pion leaves the codec empty when the first packet has payload type 0,
+// it is PCMU, RFC 3551.
+func (t *RemoteTrack) Codec() webrtc.RTPCodecParameters {
+	codec := t.TrackRemote.Codec()
+	if codec.MimeType == "" && t.PayloadType() == 0 {
+		return g711Codecs[0]
+	}
+	return codec
+}
+
+// Read read RTP packet of the audio, telephone-events are skipped
+func (t *RemoteTrack) Read(b []byte) (int, interceptor.Attributes, error) {
+	for {
+		n, attributes, err := t.TrackRemote.Read(b)
+		if err != nil {
+			t.endEvent()
+			return n, attributes, err
+		}
+		// the codec of the track follows the payload type of the packet
+		codec := t.Codec()
+		if !strings.EqualFold(codec.MimeType, mimeTypeTelephoneEvent) {
+			return n, attributes, nil
+		}
+		packet := &rtp.Packet{}
+		if err := packet.Unmarshal(b[:n]); err == nil {
+			t.handleEvent(packet, codec.ClockRate)
+		}
+	}
+}
+
+// ReadRTP read and unmarshal RTP packet of the audio, telephone-events are skipped
+func (t *RemoteTrack) ReadRTP() (*rtp.Packet, interceptor.Attributes, error) {
+	b := make([]byte, receiveMTU)
+	n, attributes, err := t.Read(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	packet := &rtp.Packet{}
+	if err := packet.Unmarshal(b[:n]); err != nil {
+		return nil, nil, err
+	}
+	return packet, attributes, nil
+}
+
+// handleEvent decode telephone-event packet, the digit is reported by the first end packet of the event
+// or by the next event if the end packets are lost
+func (t *RemoteTrack) handleEvent(packet *rtp.Packet, clockRate uint32) {
+	if len(packet.Payload) < 4 || int(packet.Payload[0]) >= len(dtmfDigits) || clockRate == 0 {
+		return
+	}
+	if !t.hasEvent || packet.Timestamp != t.eventTimestamp {
+		t.endEvent()
+		t.hasEvent = true
+		t.eventEnded = false
+		t.eventTimestamp = packet.Timestamp
+		t.eventDigit = rune(dtmfDigits[packet.Payload[0]])
+	}
+	if t.eventEnded {
+		return
+	}
+	t.eventUnits = binary.BigEndian.Uint16(packet.Payload[2:4])
+	t.eventClockRate = clockRate
+	if packet.Payload[1]&0x80 != 0 {
+		t.endEvent()
+	}
+}
+
+// endEvent report the last event to OnDTMF unless it is already reported
+func (t *RemoteTrack) endEvent() {
+	if !t.hasEvent || t.eventEnded {
+		return
+	}
+	t.eventEnded = true
+	if t.call.s.OnDTMF != nil {
+		duration := time.Duration(t.eventUnits) * time.Second / time.Duration(t.eventClockRate)
+		t.call.s.OnDTMF(t.call, t.eventDigit, duration)
+	}
+}
@@ -0,0 +1,71 @@
+package softphone
+
+import (
+	"log"
+	"net/url"
+)
+
+// replaces Replaces header which replaces the dialog of the call at the other side
+func (c *Call) replaces() Replaces {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Replaces{
+		CallID:  c.dialog.CallID,
+		ToTag:   c.dialog.RemoteTag,
+		FromTag: c.dialog.LocalTag,
+	}
+}
+
+// embeddedReplaces Replaces header embedded in the URI, false if the URI has none
+func embeddedReplaces(uri URI) (Replaces, bool, error) {
+	value, ok := uri.Headers.Get("Replaces")
+	if !ok {
+		return Replaces{}, false, nil
+	}
+	value, err := url.QueryUnescape(value)
+	if err != nil {
+		return Replaces{}, true, &ParseError{Reason: err.Error()}
+	}
+	replaces, err := ParseReplaces(value)
+	return replaces, true, err
+}
+
+// handleReplaces answer INVITE with Replaces header instead of the established call it replaces,
+// the replaced call is hung up, RFC 3891 3
+func (s *Softphone) handleReplaces(t *ServerTransaction) {
+	replaces, err := ParseReplaces(t.Request().Headers.Get("Replaces"))
+	if err != nil {
+		if err := t.Reply(400, "Bad Request"); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	s.mu.Lock()
+	replaced := s.calls[dialogKey(replaces.CallID, replaces.ToTag, replaces.FromTag)]
+	s.mu.Unlock()
+	if replaced == nil {
+		if err := t.Reply(481, "Call/Transaction Does Not Exist"); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	// calls in s.calls are confirmed, early dialogs aren't replaced
+	if replaces.EarlyOnly {
+		if err := t.Reply(486, "Busy Here"); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	call, err := s.answer(t, replaced)
+	if err != nil {
+		log.Println("replaces:", err)
+		return
+	}
+	if s.OnReplace != nil {
+		s.OnReplace(replaced, call)
+	}
+	if err := replaced.Hangup(); err != nil {
+		log.Println(err)
+	}
+}
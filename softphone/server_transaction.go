@@ -0,0 +1,260 @@
+package softphone
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrAlreadyAnswered the final response to the request was already sent
+var ErrAlreadyAnswered = errors.New("sip request already answered")
+
+// allowMethods methods supported by the softphone, value of Allow header
+const allowMethods = "ACK,BYE,CANCEL,INFO,INVITE,MESSAGE,NOTIFY,OPTIONS,PRACK,REFER,REGISTER,SUBSCRIBE,UPDATE"
+
+// ServerTransaction server transaction of a request received by the softphone, RFC 3261 17.2.
+// Retransmissions of the request are absorbed and answered with the last response.
+type ServerTransaction struct {
+	s       *Softphone
+	request SipMessage
+	key     string
+
+	mu           sync.Mutex
+	lastResponse *SipMessage
+	answered     bool
+	acked        chan struct{}
+	ackMessage   SipMessage
+	// call being answered for INVITE
+	call *Call
+	// rseq RSeq of the last reliable provisional response, pracked is closed when it is acknowledged
+	rseq    uint32
+	pracked chan struct{}
+	// prackTimedOut the request is rejected with 504 because the reliable provisional response isn't acknowledged
+	prackTimedOut bool
+	// tag To tag of responses to request outside of a dialog
+	tag string
+}
+
+// Request the received request
+func (t *ServerTransaction) Request() SipMessage {
+	return t.request
+}
+
+// Respond send response to the request, any number of provisional responses and exactly one final response
+func (t *ServerTransaction) Respond(response SipMessage) error {
+	t.addLocalTag(&response)
+	t.mu.Lock()
+	if t.answered {
+		t.mu.Unlock()
+		return ErrAlreadyAnswered
+	}
+	final := response.StatusCode() >= 200
+	t.answered = final
+	t.lastResponse = &response
+	t.mu.Unlock()
+
+	if final {
+		go t.terminate()
+	}
+	return t.s.Send(response)
+}
+
+// localTag To tag of the responses, the same for all responses of the transaction
+func (t *ServerTransaction) localTag() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tag == "" {
+		t.tag = uuid.New().String()
+	}
+	return t.tag
+}
+
+// addLocalTag add To tag to response other than 100 to request outside of a dialog, RFC 3261 8.2.6.2
+func (t *ServerTransaction) addLocalTag(response *SipMessage) {
+	if response.StatusCode() == 100 {
+		return
+	}
+	to, err := response.To()
+	if err != nil || to.Tag() != "" {
+		return
+	}
+	to.Params.Set("tag", t.localTag())
+	response.Headers.Set("To", to.String())
+}
+
+// Reply send response with status code and reason phrase
+func (t *ServerTransaction) Reply(statusCode int, reason string) error {
+	return t.Respond(NewResponse(t.request, statusCode, reason))
+}
+
+// Answered reports whether the final response was sent
+func (t *ServerTransaction) Answered() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.answered
+}
+
+// Acked is closed when ACK for the final response to INVITE is received
+func (t *ServerTransaction) Acked() <-chan struct{} {
+	return t.acked
+}
+
+// retransmit answer retransmitted request with the last response
+func (t *ServerTransaction) retransmit() {
+	t.mu.Lock()
+	response := t.lastResponse
+	t.mu.Unlock()
+	if response == nil {
+		return
+	}
+	if err := t.s.Send(*response); err != nil {
+		log.Println(err)
+	}
+}
+
+// ack ACK received for the final response
+func (t *ServerTransaction) ack(ack SipMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	select {
+	case <-t.acked:
+	default:
+		t.ackMessage = ack
+		close(t.acked)
+	}
+}
+
+// ackRequest ACK received for the final response, empty if there is none
+func (t *ServerTransaction) ackRequest() SipMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ackMessage
+}
+
+// terminate keep the answered transaction to absorb retransmissions (Timer H, Timer J), then remove it
+func (t *ServerTransaction) terminate() {
+	timer := time.NewTimer(transactionTimeout)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-t.acked:
+	}
+	t.s.mu.Lock()
+	if t.s.serverTransactions[t.key] == t {
+		delete(t.s.serverTransactions, t.key)
+	}
+	t.s.mu.Unlock()
+}
+
+// handleRequest create server transaction for the request and pass it to the application,
+// retransmitted requests are answered by their transaction
+func (s *Softphone) handleRequest(request SipMessage) {
+	if request.Method() == "ACK" {
+		s.handleAck(request)
+		return
+	}
+	key, err := transactionKey(request)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	s.mu.Lock()
+	if t, ok := s.serverTransactions[key]; ok {
+		s.mu.Unlock()
+		t.retransmit()
+		return
+	}
+	t := &ServerTransaction{
+		s:       s,
+		request: request,
+		key:     key,
+		acked:   make(chan struct{}),
+	}
+	s.serverTransactions[key] = t
+	s.mu.Unlock()
+
+	switch request.Method() {
+	case "INVITE":
+		if err := t.Reply(100, "Trying"); err != nil {
+			log.Println(err)
+			return
+		}
+		if to, err := request.To(); err == nil && to.Tag() != "" {
+			go s.handleOffer(t)
+			break
+		}
+		if request.Headers.Has("Replaces") {
+			go s.handleReplaces(t)
+			break
+		}
+		if s.OnInvite == nil {
+			err = t.Reply(480, "Temporarily Unavailable")
+			break
+		}
+		go s.OnInvite(t)
+	case "BYE":
+		err = s.handleBye(t)
+	case "CANCEL":
+		err = s.handleCancel(t)
+	case "PRACK":
+		err = s.handlePrack(t)
+	case "UPDATE":
+		go s.handleOffer(t)
+	case "REFER":
+		err = s.handleRefer(t)
+	case "NOTIFY":
+		err = s.handleNotify(t)
+	case "MESSAGE":
+		err = s.handleMessage(t)
+	case "INFO":
+		err = s.handleInfo(t)
+	case "SUBSCRIBE":
+		// the softphone is not a notifier of any event package
+		err = t.Reply(489, "Bad Event")
+	case "OPTIONS":
+		response := NewResponse(request, 200, "OK")
+		response.Headers.Add("Allow", allowMethods)
+		err = t.Respond(response)
+	default:
+		err = t.Reply(501, "Not Implemented")
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// handleAck pass ACK to the INVITE transaction: ACK for non-2xx has the branch of INVITE,
+// ACK for 2xx has its own branch and is matched by Call-ID and CSeq number
+func (s *Softphone) handleAck(ack SipMessage) {
+	via, err := ack.Via()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	cseq, err := ack.CSeq()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	s.mu.Lock()
+	t, ok := s.serverTransactions[via.Branch()+" INVITE"]
+	if !ok {
+		for _, transaction := range s.serverTransactions {
+			inviteCSeq, err := transaction.request.CSeq()
+			if err != nil || inviteCSeq.Method != "INVITE" {
+				continue
+			}
+			if inviteCSeq.Seq == cseq.Seq && transaction.request.Headers.Get("Call-ID") == ack.Headers.Get("Call-ID") {
+				t, ok = transaction, true
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+	if ok {
+		t.ack(ack)
+	}
+}
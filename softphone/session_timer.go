@@ -0,0 +1,236 @@
+package softphone
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSessionExpires session interval in seconds requested by the softphone, RFC 4028
+const DefaultSessionExpires = 1800
+
+// minSessionExpires Min-SE of the softphone, the smallest interval allowed by RFC 4028
+const minSessionExpires = 90
+
+// ErrSessionIntervalTooSmall incoming INVITE was rejected with 422 Session Interval Too Small
+var ErrSessionIntervalTooSmall = errors.New("session interval is too small")
+
+// sessionExpires session interval of outgoing calls
+func (s *Softphone) sessionExpires() uint32 {
+	switch {
+	case s.options.SessionExpires == 0:
+		return DefaultSessionExpires
+	case s.options.SessionExpires < minSessionExpires:
+		return minSessionExpires
+	}
+	return s.options.SessionExpires
+}
+
+// retryWithMinSE copy of INVITE rejected with 422 with session interval raised to Min-SE of the response,
+// new branch and next CSeq, RFC 4028 7.4
+func (s *Softphone) retryWithMinSE(request, response SipMessage) (SipMessage, error) {
+	minSE, err := strconv.ParseUint(strings.TrimSpace(strings.SplitN(response.Headers.Get("Min-SE"), ";", 2)[0]), 10, 32)
+	if err != nil {
+		return SipMessage{}, fmt.Errorf("%s without valid Min-SE", response.Subject)
+	}
+	retry := SipMessage{
+		Subject: request.Subject,
+		Headers: append(Headers{}, request.Headers...),
+		Body:    request.Body,
+	}
+	retry.Headers.Set("Session-Expires", SessionExpires{Delta: uint32(minSE)}.String())
+	retry.Headers.Set("Min-SE", strconv.FormatUint(minSE, 10))
+	retry.Headers.Set("Via", s.via().String())
+	retry.IncreaseSeq()
+	return retry, nil
+}
+
+// rejectSmallInterval reply 422 to INVITE or UPDATE with session interval below our Min-SE, RFC 4028 8.1
+func rejectSmallInterval(t *ServerTransaction) (bool, error) {
+	request := t.Request()
+	if !request.Headers.Has("Session-Expires") {
+		return false, nil
+	}
+	sessionExpires, err := ParseSessionExpires(request.Headers.Get("Session-Expires"))
+	if err != nil {
+		return true, t.Reply(400, "Bad Request")
+	}
+	if sessionExpires.Delta >= minSessionExpires {
+		return false, nil
+	}
+	response := NewResponse(request, 422, "Session Interval Too Small")
+	response.Headers.Add("Min-SE", strconv.Itoa(minSessionExpires))
+	return true, t.Respond(response)
+}
+
+// addSessionHeaders session timer headers of INVITE or UPDATE sent in the dialog, we refresh by this request
+// if we are the refresher. c.mu must be held.
+func (c *Call) addSessionHeaders(request *SipMessage) {
+	request.Headers.Add("Supported", "timer")
+	if c.sessionInterval == 0 {
+		return
+	}
+	refresher := "uas"
+	if c.refresher {
+		refresher = "uac"
+	}
+	request.Headers.Add("Session-Expires", SessionExpires{Delta: c.sessionInterval, Refresher: refresher}.String())
+	request.Headers.Add("Min-SE", strconv.Itoa(minSessionExpires))
+}
+
+// sessionTimerFromResponse start session timer negotiated by 2xx to INVITE or UPDATE sent by us,
+// 2xx without Session-Expires turns the timer off, RFC 4028 7.2
+func (c *Call) sessionTimerFromResponse(response SipMessage) {
+	if !response.Headers.Has("Session-Expires") {
+		c.startSessionTimer(0, false)
+		return
+	}
+	sessionExpires, err := ParseSessionExpires(response.Headers.Get("Session-Expires"))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	c.startSessionTimer(sessionExpires.Delta, sessionExpires.Refresher != "uas")
+}
+
+// sessionTimerForRequest choose the refresher for INVITE or UPDATE received by us and add session timer headers
+// to its 2xx, RFC 4028 9. The timer starts when the response is sent.
+func (c *Call) sessionTimerForRequest(request SipMessage, response *SipMessage) func() {
+	if !request.Headers.Has("Session-Expires") {
+		return func() { c.startSessionTimer(0, false) }
+	}
+	sessionExpires, err := ParseSessionExpires(request.Headers.Get("Session-Expires"))
+	if err != nil {
+		log.Println(err)
+		return func() {}
+	}
+	if sessionExpires.Refresher == "" {
+		sessionExpires.Refresher = "uas"
+		if request.HasOptionTag("Supported", "timer") {
+			sessionExpires.Refresher = "uac"
+		}
+	}
+	response.Headers.Add("Session-Expires", sessionExpires.String())
+	if sessionExpires.Refresher == "uac" {
+		response.Headers.Add("Require", "timer")
+	}
+	return func() { c.startSessionTimer(sessionExpires.Delta, sessionExpires.Refresher == "uas") }
+}
+
+// startSessionTimer restart session timer of the call with interval in seconds, 0 stops it. The refresher refreshes
+// the session in the middle of the interval, the other side sends BYE when the session isn't refreshed in time,
+// RFC 4028 10.
+func (c *Call) startSessionTimer(interval uint32, refresher bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopSessionTimer()
+	c.sessionInterval = interval
+	c.refresher = refresher
+	if interval == 0 || c.ended {
+		return
+	}
+	duration := time.Duration(interval) * time.Second
+	margin := duration / 3
+	if margin > 32*time.Second {
+		margin = 32 * time.Second
+	}
+	c.sessionDeadline = time.Now().Add(duration - margin)
+	if refresher {
+		c.sessionTimer = time.AfterFunc(duration/2, c.refreshSession)
+		return
+	}
+	c.sessionTimer = time.AfterFunc(duration-margin, c.expireSession)
+}
+
+// stopSessionTimer c.mu must be held
+func (c *Call) stopSessionTimer() {
+	if c.sessionTimer != nil {
+		c.sessionTimer.Stop()
+		c.sessionTimer = nil
+	}
+}
+
+// refreshSession send UPDATE without offer if the other side allows it, re-INVITE otherwise.
+// The call is ended if the refresh times out or gets 408 or 481, RFC 4028 10. Other failures are retried
+// until the session expires: after glare delay if an offer is pending, otherwise in the middle of the rest
+// of the interval.
+func (c *Call) refreshSession() {
+	c.mu.Lock()
+	update := c.remoteAllowsUpdate
+	c.mu.Unlock()
+	var err error
+	if update {
+		err = c.sendUpdate()
+	} else {
+		err = c.reinvite()
+	}
+	if err == nil || err == ErrCallNotEstablished {
+		return
+	}
+	log.Println("session refresh:", err)
+	e, ok := err.(*ResponseError)
+	switch {
+	case err == ErrTransactionTimeout, ok && (e.StatusCode == 408 || e.StatusCode == 481):
+		if err := c.Hangup(); err != nil && err != ErrCallNotEstablished {
+			log.Println(err)
+		}
+	case err == ErrRequestPending, ok && e.StatusCode == 491:
+		c.retryRefresh(c.glareDelay())
+	default:
+		c.mu.Lock()
+		rest := time.Until(c.sessionDeadline)
+		c.mu.Unlock()
+		c.retryRefresh(rest / 2)
+	}
+}
+
+// retryRefresh refresh the session again after delay, the session expires if the deadline comes first
+func (c *Call) retryRefresh(delay time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sessionInterval == 0 || c.ended {
+		return
+	}
+	c.stopSessionTimer()
+	if rest := time.Until(c.sessionDeadline); delay >= rest {
+		c.sessionTimer = time.AfterFunc(rest, c.expireSession)
+		return
+	}
+	c.sessionTimer = time.AfterFunc(delay, c.refreshSession)
+}
+
+// expireSession the session wasn't refreshed in time, RFC 4028 10
+func (c *Call) expireSession() {
+	log.Println("session expired")
+	if err := c.Hangup(); err != nil && err != ErrCallNotEstablished {
+		log.Println(err)
+	}
+}
+
+// sendUpdate refresh the session by UPDATE without offer
+func (c *Call) sendUpdate() error {
+	c.mu.Lock()
+	if !c.established {
+		c.mu.Unlock()
+		return ErrCallNotEstablished
+	}
+	request := c.newRequest("UPDATE")
+	c.addSessionHeaders(&request)
+	c.mu.Unlock()
+
+	response, err := c.requestWithAuth(request)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode() >= 300 {
+		return newResponseError(response)
+	}
+	c.mu.Lock()
+	c.updateRemoteTarget(response)
+	c.mu.Unlock()
+	c.sessionTimerFromResponse(response)
+	return nil
+}
@@ -7,23 +7,184 @@ import (
 	"strings"
 )
 
+// Header SIP header field
+type Header struct {
+	Name  string
+	Value string
+}
+
+// Headers SIP header fields in the order of the message, a name can be repeated
+type Headers []Header
+
+// Get returns the first value of the header
+func (headers Headers) Get(name string) string {
+	for _, header := range headers {
+		if strings.EqualFold(header.Name, name) {
+			return header.Value
+		}
+	}
+	return ""
+}
+
+// Has reports whether the header is present
+func (headers Headers) Has(name string) bool {
+	for _, header := range headers {
+		if strings.EqualFold(header.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Values returns all values of the header in order
+func (headers Headers) Values(name string) []string {
+	values := []string{}
+	for _, header := range headers {
+		if strings.EqualFold(header.Name, name) {
+			values = append(values, header.Value)
+		}
+	}
+	return values
+}
+
+// Filter returns the headers with the given names keeping their order
+func (headers Headers) Filter(names ...string) Headers {
+	res := Headers{}
+	for _, header := range headers {
+		for _, name := range names {
+			if strings.EqualFold(header.Name, name) {
+				res = append(res, header)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// Add appends a value of the header
+func (headers *Headers) Add(name, value string) {
+	*headers = append(*headers, Header{Name: name, Value: value})
+}
+
+// Set replaces all values of the header with value
+func (headers *Headers) Set(name, value string) {
+	res := Headers{}
+	found := false
+	for _, header := range *headers {
+		if strings.EqualFold(header.Name, name) {
+			if found {
+				continue
+			}
+			found = true
+			header = Header{Name: name, Value: value}
+		}
+		res = append(res, header)
+	}
+	if !found {
+		res = append(res, Header{Name: name, Value: value})
+	}
+	*headers = res
+}
+
+// Del removes all values of the header
+func (headers *Headers) Del(name string) {
+	res := Headers{}
+	for _, header := range *headers {
+		if !strings.EqualFold(header.Name, name) {
+			res = append(res, header)
+		}
+	}
+	*headers = res
+}
+
 // SipMessage SIP message
 type SipMessage struct {
 	Subject string
-	Headers map[string]string
+	Headers Headers
 	Body    string
 }
 
-// ToString from SipMessage to string message
+// NewResponse response to request with Via, From, To, Call-ID and CSeq copied from request
+func NewResponse(request SipMessage, statusCode int, reason string) SipMessage {
+	return SipMessage{
+		Subject: fmt.Sprintf("SIP/2.0 %d %s", statusCode, reason),
+		Headers: request.Headers.Filter("Via", "From", "To", "Call-ID", "CSeq"),
+		Body:    "",
+	}
+}
+
+// IsRequest reports whether the message is a request
+func (sipMessage SipMessage) IsRequest() bool {
+	return !strings.HasPrefix(sipMessage.Subject, "SIP/")
+}
+
+// Method method of the request, empty for responses
+func (sipMessage SipMessage) Method() string {
+	if !sipMessage.IsRequest() {
+		return ""
+	}
+	return strings.SplitN(sipMessage.Subject, " ", 2)[0]
+}
+
+// StatusCode status code of the response, 0 for requests
+func (sipMessage SipMessage) StatusCode() int {
+	if sipMessage.IsRequest() {
+		return 0
+	}
+	tokens := strings.SplitN(sipMessage.Subject, " ", 3)
+	if len(tokens) < 2 {
+		return 0
+	}
+	code, _ := strconv.Atoi(tokens[1])
+	return code
+}
+
+// ReasonPhrase reason phrase of the response
+func (sipMessage SipMessage) ReasonPhrase() string {
+	tokens := strings.SplitN(sipMessage.Subject, " ", 3)
+	if sipMessage.IsRequest() || len(tokens) < 3 {
+		return ""
+	}
+	return tokens[2]
+}
+
+// HasOptionTag reports whether option tag is listed in header, e.g. "100rel" in Require or Supported
+func (sipMessage SipMessage) HasOptionTag(header, tag string) bool {
+	for _, value := range sipMessage.Headers.Values(header) {
+		for _, option := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(option), tag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ToString from SipMessage to string message. Headers are written in canonical order:
+// Via and Route first, then the others in the order of the message, Content-Length last.
+// The message itself isn't modified.
 func (sipMessage SipMessage) ToString() (message string) {
-	if _, ok := sipMessage.Headers["Content-Length"]; !ok {
-		sipMessage.Headers["Content-Length"] = fmt.Sprintf("%d", len(sipMessage.Body))
+	headers := Headers{}
+	for _, first := range []string{"Via", "Route"} {
+		for _, header := range sipMessage.Headers {
+			if CanonicalHeaderName(header.Name) == first {
+				headers = append(headers, header)
+			}
+		}
+	}
+	for _, header := range sipMessage.Headers {
+		switch CanonicalHeaderName(header.Name) {
+		case "Via", "Route", "Content-Length":
+			continue
+		}
+		headers = append(headers, header)
 	}
-	sipMessage.Headers["User-Agent"] = "github.com/evgeniy-klemin/webrtc-sip-client"
+	headers.Add("Content-Length", fmt.Sprintf("%d", len(sipMessage.Body)))
+
 	list := []string{}
 	list = append(list, sipMessage.Subject)
-	for key, value := range sipMessage.Headers {
-		list = append(list, fmt.Sprintf("%s: %s", key, value))
+	for _, header := range headers {
+		list = append(list, fmt.Sprintf("%s: %s", CanonicalHeaderName(header.Name), header.Value))
 	}
 	list = append(list, "")
 	list = append(list, sipMessage.Body)
@@ -32,31 +193,63 @@ func (sipMessage SipMessage) ToString() (message string) {
 
 // IncreaseSeq increase CSeq
 func (sipMessage *SipMessage) IncreaseSeq() {
-	if value, ok := sipMessage.Headers["CSeq"]; ok {
-		tokens := strings.Split(value, " ")
-		i, err := strconv.Atoi(tokens[0])
+	if sipMessage.Headers.Has("CSeq") {
+		cseq, err := sipMessage.CSeq()
 		if err != nil {
 			log.Fatal("CSeq doesn't start with an integer")
 		}
-		tokens[0] = fmt.Sprintf("%d", i+1)
-		sipMessage.Headers["CSeq"] = strings.Join(tokens, " ")
+		cseq.Seq++
+		sipMessage.Headers.Set("CSeq", cseq.String())
 	}
 }
 
-// FromStringToSipMessage from string message to SipMessage
-func FromStringToSipMessage(message string) (sipMessage SipMessage) {
-	paragraphs := strings.Split(message, "\r\n\r\n")
-	body := strings.Join(paragraphs[1:], "\r\n\r\n")
-	paragraphs = strings.Split(paragraphs[0], "\r\n")
-	subject := paragraphs[0]
-	headers := make(map[string]string)
-	for _, line := range paragraphs[1:] {
-		tokens := strings.Split(line, ": ")
-		headers[tokens[0]] = tokens[1]
+// RequestURI Request-URI of the request
+func (sipMessage SipMessage) RequestURI() (URI, error) {
+	tokens := strings.Split(sipMessage.Subject, " ")
+	if !sipMessage.IsRequest() || len(tokens) != 3 {
+		return URI{}, &ParseError{Line: 1, Reason: fmt.Sprintf("no Request-URI in %q", sipMessage.Subject)}
 	}
-	return SipMessage{
-		Subject: subject,
-		Headers: headers,
-		Body:    body,
+	return ParseURI(tokens[1])
+}
+
+// From value of From header
+func (sipMessage SipMessage) From() (NameAddr, error) {
+	return ParseNameAddr(sipMessage.Headers.Get("From"))
+}
+
+// To value of To header
+func (sipMessage SipMessage) To() (NameAddr, error) {
+	return ParseNameAddr(sipMessage.Headers.Get("To"))
+}
+
+// CSeq value of CSeq header
+func (sipMessage SipMessage) CSeq() (CSeq, error) {
+	return ParseCSeq(sipMessage.Headers.Get("CSeq"))
+}
+
+// Vias all Via values in order, the topmost first
+func (sipMessage SipMessage) Vias() ([]Via, error) {
+	return ParseVias(strings.Join(sipMessage.Headers.Values("Via"), ","))
+}
+
+// Via the topmost Via value
+func (sipMessage SipMessage) Via() (Via, error) {
+	vias, err := sipMessage.Vias()
+	if err != nil {
+		return Via{}, err
+	}
+	if len(vias) == 0 {
+		return Via{}, &ParseError{Reason: "header Via is missing"}
 	}
+	return vias[0], nil
+}
+
+// Contacts all Contact values in order
+func (sipMessage SipMessage) Contacts() ([]NameAddr, error) {
+	return ParseNameAddrs(strings.Join(sipMessage.Headers.Values("Contact"), ","))
+}
+
+// RecordRoutes all Record-Route values in order
+func (sipMessage SipMessage) RecordRoutes() ([]NameAddr, error) {
+	return ParseNameAddrs(strings.Join(sipMessage.Headers.Values("Record-Route"), ","))
 }
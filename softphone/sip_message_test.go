@@ -0,0 +1,44 @@
+package softphone
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSipMessageToString(t *testing.T) {
+	sipMessage := SipMessage{
+		Subject: "INVITE sip:bob@example.com SIP/2.0",
+		Headers: Headers{
+			{"From", "<sip:alice@example.com>;tag=1"},
+			{"route", "<sip:p2.example.com;lr>"},
+			{"To", "<sip:bob@example.com>"},
+			{"v", "SIP/2.0/WSS a.invalid;branch=z9hG4bK1"},
+			{"Content-Length", "999"},
+			{"Route", "<sip:p3.example.com;lr>"},
+			{"VIA", "SIP/2.0/WSS b.invalid;branch=z9hG4bK2"},
+			{"i", "abc"},
+			{"CSeq", "1 INVITE"},
+		},
+		Body: "v=0\r\n",
+	}
+	before := append(Headers{}, sipMessage.Headers...)
+
+	expected := "INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/WSS a.invalid;branch=z9hG4bK1\r\n" +
+		"Via: SIP/2.0/WSS b.invalid;branch=z9hG4bK2\r\n" +
+		"Route: <sip:p2.example.com;lr>\r\n" +
+		"Route: <sip:p3.example.com;lr>\r\n" +
+		"From: <sip:alice@example.com>;tag=1\r\n" +
+		"To: <sip:bob@example.com>\r\n" +
+		"Call-ID: abc\r\n" +
+		"CSeq: 1 INVITE\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"v=0\r\n"
+	if message := sipMessage.ToString(); message != expected {
+		t.Errorf("message\n%q\nexpected\n%q", message, expected)
+	}
+	if !reflect.DeepEqual(sipMessage.Headers, before) {
+		t.Errorf("headers are changed to %v", sipMessage.Headers)
+	}
+}
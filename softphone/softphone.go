@@ -7,10 +7,9 @@ import (
 	"log"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 
-	glog "github.com/ghettovoice/gosip/log"
-	"github.com/ghettovoice/gosip/sip"
-	"github.com/ghettovoice/gosip/sip/parser"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/pion/dtls/v2/examples/util"
@@ -28,32 +27,117 @@ type Options struct {
 	SRTPKey   string
 	SRTPCert  string
 	Verbose   bool
+	// UserAgent value of User-Agent header, DefaultUserAgent if empty
+	UserAgent string
+	// SessionExpires session interval of outgoing calls in seconds, DefaultSessionExpires if 0
+	SessionExpires uint32
+	// DTMFMode how Call.SendDTMF sends digits, RFC 4733 telephone-events by default
+	DTMFMode DTMFMode
+	// G711 ask the other side to send PCMU or PCMA, so received audio can be decoded by NewDecoder,
+	// e.g. for ToneDetector. Opus is sent if the other side accepts it, see AudioTrack.Codec.
+	G711 bool
 }
 
+// DefaultUserAgent default value of User-Agent header
+const DefaultUserAgent = "github.com/evgeniy-klemin/webrtc-sip-client"
+
 // Softphone softphone
 type Softphone struct {
-	options          Options
-	messageListeners map[string]func(string)
-	conn             *websocket.Conn
-	OnInvite         func(inviteMessage SipMessage)
-	OnTrack          func(remote *webrtc.TrackRemote, local *webrtc.TrackLocalStaticSample)
-	fromTag          string
-	callID           string
-	cert             webrtc.Certificate
-	auth             string
+	options  Options
+	conn     *websocket.Conn
+	writeMu  sync.Mutex
+	OnInvite func(request *ServerTransaction)
+	OnTrack  func(remote *RemoteTrack, local *AudioTrack)
+	OnHangup func(call *Call, reason HangupReason)
+	// OnProgress provisional response to outgoing call, 180 Ringing or 183 Session Progress with early media
+	OnProgress func(call *Call, response SipMessage)
+	// OnHold the other side put the call on hold or took it off hold
+	OnHold func(call *Call, hold bool)
+	// OnTransferProgress status of the call to the target of Call.Transfer reported by the other side
+	OnTransferProgress func(call *Call, statusCode int, reason string)
+	// OnTransfer the other side transferred the call by REFER, newCall to the target is answered
+	OnTransfer func(call *Call, newCall *Call)
+	// OnMessage MESSAGE received outside of calls, it is answered with 200 OK, RFC 3428
+	OnMessage func(message SipMessage)
+	// OnNotify NOTIFY of a subscription made by Subscribe, the last one has terminated Subscription-State
+	OnNotify func(subscription *Subscription, notify SipMessage)
+	// OnMessageWaiting message summary of NOTIFY from SubscribeMessageWaiting or sent without subscription
+	OnMessageWaiting func(summary MessageSummary)
+	// OnDTMF DTMF digit received in the call by INFO or RFC 4733 telephone-event of the read RemoteTrack
+	OnDTMF func(call *Call, digit rune, duration time.Duration)
+	// OnReplace newCall answered by INVITE with Replaces takes the place of call, call is hung up after it
+	OnReplace func(call *Call, newCall *Call)
+	// OnRedirect decides whether outgoing call follows Contact target of 3xx response, all targets are followed if nil
+	OnRedirect func(call *Call, response SipMessage, target NameAddr) bool
+	fromTag    string
+	callID     string
+	cert       webrtc.Certificate
+
+	mu                 sync.Mutex
+	clientTransactions map[string]*ClientTransaction
+	serverTransactions map[string]*ServerTransaction
+	calls              map[string]*Call
+	subscriptions      map[string]*Subscription
 }
 
 func New(options Options, cert webrtc.Certificate) *Softphone {
+	if options.UserAgent == "" {
+		options.UserAgent = DefaultUserAgent
+	}
 	res := &Softphone{
-		options:          options,
-		messageListeners: make(map[string]func(string)),
-		cert:             cert,
+		options:            options,
+		cert:               cert,
+		clientTransactions: make(map[string]*ClientTransaction),
+		serverTransactions: make(map[string]*ServerTransaction),
+		calls:              make(map[string]*Call),
+		subscriptions:      make(map[string]*Subscription),
 	}
 	return res
 }
 
 var fakeDomain = fmt.Sprintf("%s.invalid", uuid.New().String())
-var fakeEmail = fmt.Sprintf("%s@%s", uuid.New().String(), fakeDomain)
+var fakeUser = uuid.New().String()
+
+// contact Contact address of the softphone
+func (s *Softphone) contact() NameAddr {
+	return NameAddr{
+		URI: URI{
+			Scheme: "sip",
+			User:   fakeUser,
+			Host:   fakeDomain,
+			Params: Params{{"transport", strings.ToLower(s.options.Transport)}},
+		},
+	}
+}
+
+// via Via of the softphone with new branch
+func (s *Softphone) via() Via {
+	return Via{
+		Transport: strings.ToUpper(s.options.Transport),
+		Host:      fakeDomain,
+		Params:    Params{{"branch", newBranch()}},
+	}
+}
+
+// addressOfRecord SIP URI of the user
+func (s *Softphone) addressOfRecord() URI {
+	return URI{Scheme: "sip", User: s.options.Username, Host: s.options.Domain}
+}
+
+// newRequest request outside of a dialog to the target with new Call-ID and From tag
+func (s *Softphone) newRequest(method string, to URI) SipMessage {
+	return SipMessage{
+		Subject: fmt.Sprintf("%s %s SIP/2.0", method, to),
+		Headers: Headers{
+			{"Via", s.via().String()},
+			{"Max-Forwards", "70"},
+			{"From", NameAddr{URI: s.addressOfRecord(), Params: Params{{"tag", uuid.New().String()}}}.String()},
+			{"To", NameAddr{URI: to}.String()},
+			{"Call-ID", uuid.New().String()},
+			{"CSeq", CSeq{Seq: 1, Method: method}.String()},
+		},
+	}
+}
 
 func LoadCert(keyFile, certFile string) webrtc.Certificate {
 	key, err := util.LoadKey(keyFile)
@@ -71,8 +155,8 @@ func LoadCert(keyFile, certFile string) webrtc.Certificate {
 	return webrtc.CertificateFromX509(key, certx509)
 }
 
-// Register register the softphone
-func (s *Softphone) Register() {
+// Register register the softphone, returns when the registration is finished
+func (s *Softphone) Register() error {
 	url := url.URL{
 		Scheme: s.options.Transport,
 		Host:   fmt.Sprintf("%s:%d", s.options.Host, s.options.Port),
@@ -84,69 +168,58 @@ func (s *Softphone) Register() {
 	var err error
 	s.conn, _, err = dialer.Dial(url.String(), nil)
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
-	go func() {
-		for {
-			_, bytes, err := s.conn.ReadMessage()
-			if err != nil {
-				log.Println(err)
-				return
-			}
-			message := string(bytes)
-			if s.options.Verbose {
-				log.Println("↓↓↓\n", message)
-			}
-			for _, messageListener := range s.messageListeners {
-				go messageListener(message)
-			}
-		}
-	}()
+	go s.readMessages()
 
 	s.fromTag = uuid.New().String()
 	s.callID = uuid.New().String()
 
+	contact := s.contact()
+	contact.Params = Params{{"expires", "600"}}
 	registerMessage := SipMessage{
 		Subject: fmt.Sprintf("REGISTER sip:%s SIP/2.0", s.options.Domain),
-		Headers: map[string]string{
-			"Call-ID": s.callID,
-			"Contact": fmt.Sprintf("<sip:%s;transport=%s>;expires=600", fakeEmail, s.options.Transport),
-			"Via":     fmt.Sprintf("SIP/2.0/%s %s;branch=z9hG4bK%s", strings.ToUpper(s.options.Transport), fakeDomain, uuid.New().String()),
-			"From":    fmt.Sprintf("<sip:%s@%s>;tag=%s", s.options.Username, s.options.Domain, s.fromTag),
-			"To":      fmt.Sprintf("<sip:%s@%s>", s.options.Username, s.options.Domain),
-			"CSeq":    "8082 REGISTER",
+		Headers: Headers{
+			{"Call-ID", s.callID},
+			{"Contact", contact.String()},
+			{"Via", s.via().String()},
+			{"From", NameAddr{URI: s.addressOfRecord(), Params: Params{{"tag", s.fromTag}}}.String()},
+			{"To", NameAddr{URI: s.addressOfRecord()}.String()},
+			{"CSeq", CSeq{Seq: 8082, Method: "REGISTER"}.String()},
 		},
 		Body: "",
 	}
-	s.Send(registerMessage, func(strMessage string) bool {
-		logger := glog.NewDefaultLogrusLogger()
-		msg, err := parser.ParseMessage([]byte(strMessage), logger)
+	_, response, err := s.requestWithAuth(registerMessage)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode() >= 300 {
+		return newResponseError(response)
+	}
+	return nil
+}
+
+// readMessages read messages from WebSocket, responses go to their client transactions,
+// requests to server transactions
+func (s *Softphone) readMessages() {
+	for {
+		_, bytes, err := s.conn.ReadMessage()
 		if err != nil {
-			return false
+			log.Println(err)
+			return
 		}
-		if strings.Contains(msg.StartLine(), "SIP/2.0 401 Unauthorized") {
-			headers := msg.GetHeaders("WWW-Authenticate")
-			auth := sip.AuthFromValue(headers[0].Value()).
-				SetMethod("REGISTER").
-				SetUsername(s.options.Username).
-				SetPassword(s.options.Password)
-			auth.SetResponse(auth.CalcResponse())
-
-			registerMessage.Headers["Authorization"] = auth.String()
-			s.auth = auth.String()
-			registerMessage.IncreaseSeq()
-			registerMessage.Headers["Via"] = fmt.Sprintf("SIP/2.0/%s %s;branch=z9hG4bK%s", strings.ToUpper(s.options.Transport), fakeDomain, uuid.New().String())
-			s.Send(registerMessage, nil)
-
-			return true
+		if s.options.Verbose {
+			log.Println("↓↓↓\n", string(bytes))
 		}
-		return false
-	})
-
-	s.addMessageListener(func(strMessage string) {
-		if strings.Contains(strMessage, "INVITE sip:") {
-			s.OnInvite(FromStringToSipMessage(strMessage))
+		message, err := ParseSipMessage(string(bytes))
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if !message.IsRequest() {
+			s.handleResponse(message)
+			continue
 		}
-	})
+		s.handleRequest(message)
+	}
 }
@@ -0,0 +1,329 @@
+package softphone
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultSubscribeExpires duration of subscription in seconds requested by Subscribe if expires is 0
+const DefaultSubscribeExpires = 3600
+
+// ErrSubscriptionTerminated the subscription is already terminated
+var ErrSubscriptionTerminated = errors.New("subscription is terminated")
+
+// Subscription subscription to event package of a resource, RFC 6665.
+// It is refreshed until Unsubscribe or until the notifier terminates it.
+type Subscription struct {
+	s     *Softphone
+	event string
+	key   string
+
+	mu      sync.Mutex
+	dialog  Dialog
+	state   SubscriptionState
+	expires uint32
+	timer   *time.Timer
+	ended   bool
+	done    chan struct{}
+	// notifications NOTIFY requests waiting for OnNotify in order, queued is signaled when one is added
+	// or the subscription is terminated. The queue is unbounded, so no NOTIFY is lost to a slow OnNotify.
+	notifications []SipMessage
+	queued        *sync.Cond
+}
+
+// subscriptionKey key of the subscription in Softphone.subscriptions, the notifier sets the remote tag by NOTIFY
+func subscriptionKey(callID, localTag string) string {
+	return fmt.Sprintf("%s %s", callID, localTag)
+}
+
+// Subscribe subscribe to event package of target, extension or SIP URI, for expires seconds, RFC 6665 4.1.
+// Accept is the value of Accept header, the default body type of the package if empty.
+// Returns when the subscription is accepted, notifications go to OnNotify.
+func (s *Softphone) Subscribe(target, event, accept string, expires uint32) (*Subscription, error) {
+	uri, err := s.targetURI(target)
+	if err != nil {
+		return nil, err
+	}
+	if expires == 0 {
+		expires = DefaultSubscribeExpires
+	}
+	sub := &Subscription{
+		s:     s,
+		event: event,
+		dialog: Dialog{
+			CallID:       uuid.New().String(),
+			LocalTag:     uuid.New().String(),
+			LocalURI:     NameAddr{URI: s.addressOfRecord()},
+			RemoteURI:    NameAddr{URI: uri},
+			RemoteTarget: uri,
+		},
+		state:   SubscriptionState{State: "pending"},
+		expires: expires,
+		done:    make(chan struct{}),
+	}
+	sub.queued = sync.NewCond(&sub.mu)
+	sub.key = subscriptionKey(sub.dialog.CallID, sub.dialog.LocalTag)
+	go sub.deliver()
+
+	// NOTIFY can come before the response to SUBSCRIBE
+	s.mu.Lock()
+	s.subscriptions[sub.key] = sub
+	s.mu.Unlock()
+
+	sub.mu.Lock()
+	request := sub.newSubscribe(expires)
+	sub.mu.Unlock()
+	if accept != "" {
+		request.Headers.Add("Accept", accept)
+	}
+	if err := sub.subscribe(request); err != nil {
+		sub.close()
+		return nil, err
+	}
+	return sub, nil
+}
+
+// Event event package of the subscription
+func (sub *Subscription) Event() string {
+	return sub.event
+}
+
+// Target resource of the subscription
+func (sub *Subscription) Target() URI {
+	return sub.dialog.RemoteURI.URI
+}
+
+// State state of the subscription reported by the last NOTIFY, "pending" before the first one
+func (sub *Subscription) State() SubscriptionState {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.state
+}
+
+// Done is closed when the subscription is terminated
+func (sub *Subscription) Done() <-chan struct{} {
+	return sub.done
+}
+
+// Unsubscribe send SUBSCRIBE with zero expiration, the subscription is terminated by the final NOTIFY
+// or transactionTimeout later, RFC 6665 4.1.2.3
+func (sub *Subscription) Unsubscribe() error {
+	sub.mu.Lock()
+	if sub.ended {
+		sub.mu.Unlock()
+		return ErrSubscriptionTerminated
+	}
+	sub.stopTimer()
+	request := sub.newSubscribe(0)
+	sub.mu.Unlock()
+
+	err := sub.subscribe(request)
+	if err != nil {
+		sub.close()
+		return err
+	}
+	time.AfterFunc(transactionTimeout, func() { sub.close() })
+	return nil
+}
+
+// newSubscribe SUBSCRIBE in the dialog of the subscription. sub.mu must be held.
+func (sub *Subscription) newSubscribe(expires uint32) SipMessage {
+	request := sub.s.dialogRequest(&sub.dialog, "SUBSCRIBE")
+	request.Headers.Add("Event", sub.event)
+	request.Headers.Add("Expires", strconv.FormatUint(uint64(expires), 10))
+	return request
+}
+
+// subscribe send SUBSCRIBE and apply its 2xx response: the dialog and the refresh timer
+func (sub *Subscription) subscribe(request SipMessage) error {
+	request, response, err := sub.s.requestWithAuth(request)
+	// CSeq of the request is increased by authorization
+	if cseq, err := request.CSeq(); err == nil {
+		sub.mu.Lock()
+		if cseq.Seq > sub.dialog.LocalSeq {
+			sub.dialog.LocalSeq = cseq.Seq
+		}
+		sub.mu.Unlock()
+	}
+	if err != nil {
+		return err
+	}
+	if response.StatusCode() >= 300 {
+		return newResponseError(response)
+	}
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.dialog.RemoteTag == "" {
+		to, err := response.To()
+		if err != nil {
+			return err
+		}
+		routes, err := response.RecordRoutes()
+		if err != nil {
+			return err
+		}
+		sub.dialog.RemoteTag = to.Tag()
+		sub.dialog.RouteSet = []NameAddr{}
+		for i := len(routes) - 1; i >= 0; i-- {
+			sub.dialog.RouteSet = append(sub.dialog.RouteSet, routes[i])
+		}
+	}
+	if contacts, err := response.Contacts(); err == nil && len(contacts) > 0 {
+		sub.dialog.RemoteTarget = contacts[0].URI
+	}
+	// the notifier can shorten the duration, RFC 6665 4.1.2.1
+	expires, err := strconv.ParseUint(strings.TrimSpace(response.Headers.Get("Expires")), 10, 32)
+	if err != nil {
+		expires, _ = strconv.ParseUint(request.Headers.Get("Expires"), 10, 32)
+	}
+	sub.startTimer(uint32(expires))
+	return nil
+}
+
+// refresh send SUBSCRIBE before the subscription expires, the subscription is terminated if it fails
+func (sub *Subscription) refresh() {
+	sub.mu.Lock()
+	if sub.ended {
+		sub.mu.Unlock()
+		return
+	}
+	request := sub.newSubscribe(sub.expires)
+	sub.mu.Unlock()
+
+	if err := sub.subscribe(request); err != nil {
+		log.Println("subscription refresh:", err)
+		sub.close()
+	}
+}
+
+// startTimer refresh the subscription in the middle of expires seconds. sub.mu must be held.
+func (sub *Subscription) startTimer(expires uint32) {
+	sub.stopTimer()
+	if expires == 0 || sub.ended {
+		return
+	}
+	sub.timer = time.AfterFunc(time.Duration(expires)*time.Second/2, sub.refresh)
+}
+
+// stopTimer sub.mu must be held
+func (sub *Subscription) stopTimer() {
+	if sub.timer != nil {
+		sub.timer.Stop()
+		sub.timer = nil
+	}
+}
+
+// deliver pass notifications to OnNotify and OnMessageWaiting in order until the subscription is terminated
+// and all its notifications are passed
+func (sub *Subscription) deliver() {
+	for {
+		sub.mu.Lock()
+		for len(sub.notifications) == 0 && !sub.ended {
+			sub.queued.Wait()
+		}
+		if len(sub.notifications) == 0 {
+			sub.mu.Unlock()
+			return
+		}
+		notify := sub.notifications[0]
+		sub.notifications = sub.notifications[1:]
+		sub.mu.Unlock()
+
+		if sub.s.OnNotify != nil {
+			sub.s.OnNotify(sub, notify)
+		}
+		if strings.EqualFold(sub.event, "message-summary") {
+			sub.s.messageWaiting(notify)
+		}
+	}
+}
+
+// close forget the subscription, returns false if it is already terminated
+func (sub *Subscription) close() bool {
+	sub.mu.Lock()
+	if sub.ended {
+		sub.mu.Unlock()
+		return false
+	}
+	sub.ended = true
+	sub.stopTimer()
+	sub.queued.Signal()
+	close(sub.done)
+	sub.mu.Unlock()
+
+	sub.s.mu.Lock()
+	if sub.s.subscriptions[sub.key] == sub {
+		delete(sub.s.subscriptions, sub.key)
+	}
+	sub.s.mu.Unlock()
+	return true
+}
+
+// handleSubscriptionNotify answer NOTIFY of a subscription and pass it to OnNotify, RFC 6665 4.1.3
+func (s *Softphone) handleSubscriptionNotify(t *ServerTransaction, event string) error {
+	request := t.Request()
+	from, err := request.From()
+	if err != nil {
+		return t.Reply(400, "Bad Request")
+	}
+	to, err := request.To()
+	if err != nil {
+		return t.Reply(400, "Bad Request")
+	}
+	s.mu.Lock()
+	sub := s.subscriptions[subscriptionKey(request.Headers.Get("Call-ID"), to.Tag())]
+	s.mu.Unlock()
+	if sub == nil {
+		return t.Reply(481, "Subscription Does Not Exist")
+	}
+	if !strings.EqualFold(sub.event, event) {
+		return t.Reply(489, "Bad Event")
+	}
+	state, err := ParseSubscriptionState(request.Headers.Get("Subscription-State"))
+	if err != nil || !request.Headers.Has("Subscription-State") {
+		return t.Reply(400, "Bad Request")
+	}
+
+	sub.mu.Lock()
+	if sub.ended {
+		sub.mu.Unlock()
+		return t.Reply(481, "Subscription Does Not Exist")
+	}
+	// the first NOTIFY can create the dialog, RFC 6665 4.1.2.4
+	if sub.dialog.RemoteTag == "" {
+		routes, err := request.RecordRoutes()
+		if err != nil {
+			sub.mu.Unlock()
+			return t.Reply(400, "Bad Request")
+		}
+		sub.dialog.RemoteTag = from.Tag()
+		sub.dialog.RouteSet = routes
+	} else if sub.dialog.RemoteTag != from.Tag() {
+		sub.mu.Unlock()
+		return t.Reply(481, "Subscription Does Not Exist")
+	}
+	if contacts, err := request.Contacts(); err == nil && len(contacts) > 0 {
+		sub.dialog.RemoteTarget = contacts[0].URI
+	}
+	sub.state = state
+	if state.State != "terminated" && state.Expires > 0 {
+		sub.startTimer(state.Expires)
+	}
+	sub.notifications = append(sub.notifications, request)
+	sub.queued.Signal()
+	sub.mu.Unlock()
+
+	err = t.Reply(200, "OK")
+	if state.State == "terminated" {
+		sub.close()
+	}
+	return err
+}
@@ -0,0 +1,364 @@
+package softphone
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// Tone call progress tone
+type Tone int
+
+const (
+	// ToneDial dial tone, 350+440 Hz or 425 Hz continuous
+	ToneDial Tone = iota + 1
+	// ToneBusy busy or reorder tone, 480+620 Hz or 425 Hz in short bursts
+	ToneBusy
+	// ToneRingback ringback tone, 440+480 Hz or 425 Hz in bursts of 1-2 s
+	ToneRingback
+	// ToneSIT special information tone, three rising tones before an announcement
+	ToneSIT
+)
+
+// String name of the tone
+func (t Tone) String() string {
+	switch t {
+	case ToneDial:
+		return "dial tone"
+	case ToneBusy:
+		return "busy"
+	case ToneRingback:
+		return "ringback"
+	case ToneSIT:
+		return "SIT"
+	}
+	return "unknown"
+}
+
+// toneBlocksPerSecond audio is analysed in blocks of 25 ms, 40 Hz between Goertzel bins
+const toneBlocksPerSecond = 40
+
+// toneMinPower minimal mean square of block with a signal, about -50 dBFS
+const toneMinPower = 1e4
+
+// toneMinShare minimal share of energy of block in each frequency of dual tone
+const toneMinShare = 0.2
+
+// toneMinTotalShare minimal share of energy of block in the frequencies of tone, the rest is noise
+const toneMinTotalShare = 0.7
+
+// toneResetSilence silence after which the same tone is reported again
+const toneResetSilence = 6 * time.Second
+
+// dtmfRows, dtmfColumns frequencies of DTMF, dtmfKeypad digits by row and column
+var (
+	dtmfRows    = [4]float64{697, 770, 852, 941}
+	dtmfColumns = [4]float64{1209, 1336, 1477, 1633}
+	dtmfKeypad  = [4]string{"123A", "456B", "789C", "*0#D"}
+)
+
+// toneSignal what sounds in block of audio
+type toneSignal int
+
+const (
+	signalNone toneSignal = iota
+	signalDigit
+	signalDial
+	signalRingback
+	signalBusy
+	signal425
+	signalSIT1
+	signalSIT2
+	signalSIT3
+)
+
+// dualTones, singleTones frequencies of call progress tones, ANSI T1.401, ITU-T E.180 and ANSI T1.209 for SIT
+var (
+	dualTones = []struct {
+		low, high float64
+		signal    toneSignal
+	}{
+		{350, 440, signalDial},
+		{440, 480, signalRingback},
+		{480, 620, signalBusy},
+	}
+	singleTones = []struct {
+		frequency float64
+		signal    toneSignal
+	}{
+		{425, signal425},
+		{913.8, signalSIT1},
+		{985.2, signalSIT1},
+		{1370.6, signalSIT2},
+		{1428.5, signalSIT2},
+		{1776.7, signalSIT3},
+	}
+)
+
+// ToneDetector detector of DTMF digits and call progress tones in received audio by Goertzel algorithm.
+// It is a media.Writer for RTP packets of the remote track. WriteRTP, Write and Close are called from one goroutine,
+// results can be read from any goroutine.
+type ToneDetector struct {
+	// OnDTMF called when DTMF digit ends
+	OnDTMF func(digit rune, duration time.Duration)
+	// OnTone called when call progress tone is recognized by its frequencies and cadence
+	OnTone func(tone Tone)
+
+	decoder    Decoder
+	sampleRate int
+	blockSize  int
+	// block samples of incomplete block
+	block []int16
+
+	// signal of the last blocks and their number
+	signal toneSignal
+	digit  rune
+	blocks int
+	// cadence of busy and SIT
+	busyBursts int
+	sitStep    int
+	// lastTone is reported once until the signal changes
+	lastTone Tone
+
+	mu     sync.Mutex
+	digits []rune
+	tones  []Tone
+}
+
+// NewToneDetector detector of audio decoded by the decoder
+func NewToneDetector(decoder Decoder) *ToneDetector {
+	sampleRate := decoder.SampleRate()
+	return &ToneDetector{
+		decoder:    decoder,
+		sampleRate: sampleRate,
+		blockSize:  sampleRate / toneBlocksPerSecond,
+	}
+}
+
+// WriteRTP decode RTP packet and detect tones in the audio
+func (d *ToneDetector) WriteRTP(packet *rtp.Packet) error {
+	samples, err := d.decoder.Decode(packet.Payload)
+	if err != nil {
+		return err
+	}
+	d.Write(samples)
+	return nil
+}
+
+// Write detect tones in decoded audio
+func (d *ToneDetector) Write(samples []int16) {
+	for len(samples) > 0 {
+		n := d.blockSize - len(d.block)
+		if n > len(samples) {
+			n = len(samples)
+		}
+		d.block = append(d.block, samples[:n]...)
+		samples = samples[n:]
+		if len(d.block) == d.blockSize {
+			d.analyse(d.block)
+			d.block = d.block[:0]
+		}
+	}
+}
+
+// Close report the digit which sounds at the end of the audio
+func (d *ToneDetector) Close() error {
+	d.endSignal()
+	d.signal, d.digit, d.blocks = signalNone, 0, 0
+	return nil
+}
+
+// Digits detected DTMF digits
+func (d *ToneDetector) Digits() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return string(d.digits)
+}
+
+// Tones detected call progress tones in order of detection
+func (d *ToneDetector) Tones() []Tone {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Tone{}, d.tones...)
+}
+
+// Heard the tone was detected
+func (d *ToneDetector) Heard(tone Tone) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, t := range d.tones {
+		if t == tone {
+			return true
+		}
+	}
+	return false
+}
+
+// analyse classify block and follow the cadence of signals
+func (d *ToneDetector) analyse(block []int16) {
+	signal, digit := d.classify(block)
+	if signal == d.signal && digit == d.digit {
+		d.blocks++
+	} else {
+		d.endSignal()
+		d.signal, d.digit, d.blocks = signal, digit, 1
+	}
+
+	// continuous tones are reported while they sound
+	duration := d.duration()
+	switch {
+	case d.signal == signalDial && duration >= time.Second:
+		d.reportTone(ToneDial)
+	case d.signal == signalRingback && duration >= 800*time.Millisecond:
+		d.reportTone(ToneRingback)
+	case d.signal == signal425 && duration >= 3*time.Second:
+		d.reportTone(ToneDial)
+	case d.signal == signalNone && duration >= toneResetSilence:
+		d.lastTone = 0
+	}
+}
+
+// duration duration of the current signal
+func (d *ToneDetector) duration() time.Duration {
+	return time.Duration(d.blocks) * time.Second / toneBlocksPerSecond
+}
+
+// endSignal the current signal ended, digits and tones with cadence are reported
+func (d *ToneDetector) endSignal() {
+	duration := d.duration()
+	if d.signal == signalNone {
+		// a short gap is a block with two signals
+		if duration > time.Second {
+			d.busyBursts = 0
+		}
+		if d.blocks > 2 {
+			d.sitStep = 0
+		}
+		return
+	}
+	if d.signal != signalBusy && d.signal != signal425 {
+		d.busyBursts = 0
+	}
+	if d.signal < signalSIT1 {
+		d.sitStep = 0
+	}
+	burst := duration >= 150*time.Millisecond && duration <= 800*time.Millisecond
+
+	switch d.signal {
+	case signalDigit:
+		// one block can be noise
+		if d.blocks >= 2 {
+			d.reportDigit(d.digit, duration)
+		}
+	case signalBusy, signal425:
+		if burst {
+			d.busyBursts++
+			if d.busyBursts >= 2 {
+				d.reportTone(ToneBusy)
+			}
+		} else {
+			d.busyBursts = 0
+			if d.signal == signal425 && duration > 800*time.Millisecond && duration <= 2500*time.Millisecond {
+				d.reportTone(ToneRingback)
+			}
+		}
+	case signalSIT1, signalSIT2, signalSIT3:
+		// the segments rise in frequency without gaps, each lasts 274 or 380 ms
+		step := int(d.signal-signalSIT1) + 1
+		segment := duration >= 150*time.Millisecond && duration <= 500*time.Millisecond
+		switch {
+		case segment && (step == d.sitStep+1 || step == 1):
+			d.sitStep = step
+		default:
+			d.sitStep = 0
+		}
+		if d.sitStep == 3 {
+			d.sitStep = 0
+			d.reportTone(ToneSIT)
+		}
+	}
+}
+
+// classify find DTMF digit or call progress tone in block
+func (d *ToneDetector) classify(block []int16) (toneSignal, rune) {
+	energy := 0.0
+	for _, sample := range block {
+		energy += float64(sample) * float64(sample)
+	}
+	if energy/float64(len(block)) < toneMinPower {
+		return signalNone, 0
+	}
+	// share of energy of block in the frequency, 1 for a pure sine
+	share := func(frequency float64) float64 {
+		return 2 * goertzel(block, frequency, d.sampleRate) / (float64(len(block)) * energy)
+	}
+
+	row, rowShare := strongest(dtmfRows, share)
+	column, columnShare := strongest(dtmfColumns, share)
+	// twist is limited to 8 dB for the column and 4 dB for the row
+	if rowShare >= toneMinShare && columnShare >= toneMinShare && rowShare+columnShare >= toneMinTotalShare &&
+		columnShare <= rowShare*6.3 && rowShare <= columnShare*2.5 {
+		return signalDigit, rune(dtmfKeypad[row][column])
+	}
+
+	for _, tone := range dualTones {
+		low, high := share(tone.low), share(tone.high)
+		if low >= toneMinShare && high >= toneMinShare && low+high >= toneMinTotalShare {
+			return tone.signal, 0
+		}
+	}
+	for _, tone := range singleTones {
+		if share(tone.frequency) >= toneMinTotalShare {
+			return tone.signal, 0
+		}
+	}
+	return signalNone, 0
+}
+
+// strongest index and share of the strongest frequency
+func strongest(frequencies [4]float64, share func(frequency float64) float64) (int, float64) {
+	index, max := 0, 0.0
+	for i, frequency := range frequencies {
+		if s := share(frequency); s > max {
+			index, max = i, s
+		}
+	}
+	return index, max
+}
+
+// goertzel power of the frequency in the samples
+func goertzel(samples []int16, frequency float64, sampleRate int) float64 {
+	coefficient := 2 * math.Cos(2*math.Pi*frequency/float64(sampleRate))
+	var s1, s2 float64
+	for _, sample := range samples {
+		s1, s2 = float64(sample)+coefficient*s1-s2, s1
+	}
+	return s1*s1 + s2*s2 - coefficient*s1*s2
+}
+
+// reportDigit save the digit and call OnDTMF
+func (d *ToneDetector) reportDigit(digit rune, duration time.Duration) {
+	d.lastTone = 0
+	d.mu.Lock()
+	d.digits = append(d.digits, digit)
+	d.mu.Unlock()
+	if d.OnDTMF != nil {
+		d.OnDTMF(digit, duration)
+	}
+}
+
+// reportTone save the tone and call OnTone unless it is reported already
+func (d *ToneDetector) reportTone(tone Tone) {
+	if tone == d.lastTone {
+		return
+	}
+	d.lastTone = tone
+	d.mu.Lock()
+	d.tones = append(d.tones, tone)
+	d.mu.Unlock()
+	if d.OnTone != nil {
+		d.OnTone(tone)
+	}
+}
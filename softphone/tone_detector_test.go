@@ -0,0 +1,168 @@
+package softphone
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// toneGenerator 8000 Hz audio of sums of sine waves
+type toneGenerator struct {
+	samples []int16
+}
+
+// add frequencies of amplitude for duration, silence without frequencies
+func (g *toneGenerator) add(duration time.Duration, amplitude float64, frequencies ...float64) {
+	n := int(duration.Seconds() * 8000)
+	for i := 0; i < n; i++ {
+		v := 0.0
+		for _, f := range frequencies {
+			v += amplitude * math.Sin(2*math.Pi*f*float64(len(g.samples))/8000)
+		}
+		g.samples = append(g.samples, int16(v))
+	}
+}
+
+// encodeULaw encode sample to μ-law, ITU-T G.711
+func encodeULaw(sample int16) byte {
+	v := int(sample)
+	sign := byte(0)
+	if v < 0 {
+		v = -v
+		sign = 0x80
+	}
+	if v > 32635 {
+		v = 32635
+	}
+	v += 0x84
+	exponent := 7
+	for mask := 0x4000; v&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := (v >> (exponent + 3)) & 0x0f
+	return ^(sign | byte(exponent<<4) | byte(mantissa))
+}
+
+// detect pass the audio to ToneDetector in PCMU RTP packets of 20 ms
+func detect(t *testing.T, samples []int16) *ToneDetector {
+	decoder, err := NewDecoder(webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	detector := NewToneDetector(decoder)
+	for i := 0; i < len(samples); i += 160 {
+		end := i + 160
+		if end > len(samples) {
+			end = len(samples)
+		}
+		packet := &rtp.Packet{}
+		for _, sample := range samples[i:end] {
+			packet.Payload = append(packet.Payload, encodeULaw(sample))
+		}
+		if err := detector.WriteRTP(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := detector.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return detector
+}
+
+func TestToneDetectorDTMF(t *testing.T) {
+	g := &toneGenerator{}
+	g.add(100*time.Millisecond, 5000, 697, 1209)
+	g.add(60*time.Millisecond, 0)
+	g.add(80*time.Millisecond, 5000, 770, 1336)
+	g.add(100*time.Millisecond, 0)
+	g.add(200*time.Millisecond, 3000, 941, 1477)
+	g.add(300*time.Millisecond, 0)
+	g.add(90*time.Millisecond, 4000, 852, 1633)
+
+	detector := detect(t, g.samples)
+	if digits := detector.Digits(); digits != "15#C" {
+		t.Errorf("digits %q, expected %q", digits, "15#C")
+	}
+	if tones := detector.Tones(); len(tones) != 0 {
+		t.Errorf("tones %v in DTMF", tones)
+	}
+}
+
+func TestToneDetectorRingback(t *testing.T) {
+	g := &toneGenerator{}
+	for i := 0; i < 2; i++ {
+		g.add(2*time.Second, 3000, 440, 480)
+		g.add(4*time.Second, 0)
+	}
+
+	detector := detect(t, g.samples)
+	if !detector.Heard(ToneRingback) {
+		t.Errorf("ringback isn't detected, tones %v", detector.Tones())
+	}
+	if detector.Heard(ToneBusy) || detector.Digits() != "" {
+		t.Errorf("tones %v and digits %q in ringback", detector.Tones(), detector.Digits())
+	}
+}
+
+func TestToneDetectorBusy(t *testing.T) {
+	for _, frequencies := range [][]float64{{480, 620}, {425}} {
+		g := &toneGenerator{}
+		for i := 0; i < 4; i++ {
+			g.add(500*time.Millisecond, 3000, frequencies...)
+			g.add(500*time.Millisecond, 0)
+		}
+
+		detector := detect(t, g.samples)
+		if !detector.Heard(ToneBusy) {
+			t.Errorf("busy of %v Hz isn't detected, tones %v", frequencies, detector.Tones())
+		}
+		if detector.Heard(ToneRingback) || detector.Heard(ToneDial) || detector.Digits() != "" {
+			t.Errorf("tones %v and digits %q in busy of %v Hz", detector.Tones(), detector.Digits(), frequencies)
+		}
+	}
+}
+
+func TestToneDetectorDial(t *testing.T) {
+	for _, frequencies := range [][]float64{{350, 440}, {425}} {
+		g := &toneGenerator{}
+		g.add(4*time.Second, 3000, frequencies...)
+
+		detector := detect(t, g.samples)
+		if tones := detector.Tones(); len(tones) != 1 || tones[0] != ToneDial {
+			t.Errorf("tones %v of dial tone of %v Hz", tones, frequencies)
+		}
+		if detector.Digits() != "" {
+			t.Errorf("digits %q in dial tone of %v Hz", detector.Digits(), frequencies)
+		}
+	}
+}
+
+func TestToneDetectorSIT(t *testing.T) {
+	g := &toneGenerator{}
+	g.add(200*time.Millisecond, 0)
+	g.add(274*time.Millisecond, 3000, 913.8)
+	g.add(274*time.Millisecond, 3000, 1370.6)
+	g.add(380*time.Millisecond, 3000, 1776.7)
+	g.add(time.Second, 0)
+
+	detector := detect(t, g.samples)
+	if tones := detector.Tones(); len(tones) != 1 || tones[0] != ToneSIT {
+		t.Errorf("tones %v of SIT", tones)
+	}
+	if detector.Digits() != "" {
+		t.Errorf("digits %q in SIT", detector.Digits())
+	}
+
+	// the segments in another order aren't SIT
+	g = &toneGenerator{}
+	g.add(380*time.Millisecond, 3000, 1776.7)
+	g.add(274*time.Millisecond, 3000, 1370.6)
+	g.add(274*time.Millisecond, 3000, 913.8)
+	g.add(time.Second, 0)
+	if detector := detect(t, g.samples); detector.Heard(ToneSIT) {
+		t.Errorf("falling tones are SIT, tones %v", detector.Tones())
+	}
+}
@@ -0,0 +1,300 @@
+package softphone
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// T1 estimate of round-trip time, RFC 3261 17.1.1.1
+const T1 = 500 * time.Millisecond
+
+// transactionTimeout Timer B and Timer F, also how long a finished INVITE transaction absorbs retransmitted responses
+const transactionTimeout = 64 * T1
+
+// ErrTransactionTimeout the transaction got no final response in time (Timer B or Timer F)
+var ErrTransactionTimeout = errors.New("sip transaction timeout")
+
+// ResponseError final non-2xx response to a request sent by the softphone
+type ResponseError struct {
+	Method       string
+	StatusCode   int
+	ReasonPhrase string
+	// Reason values of Reason headers of the response joined by comma, RFC 3326
+	Reason string
+}
+
+func newResponseError(response SipMessage) *ResponseError {
+	cseq, _ := response.CSeq()
+	return &ResponseError{
+		Method:       cseq.Method,
+		StatusCode:   response.StatusCode(),
+		ReasonPhrase: response.ReasonPhrase(),
+		Reason:       strings.Join(response.Headers.Values("Reason"), ", "),
+	}
+}
+
+func (e *ResponseError) Error() string {
+	msg := fmt.Sprintf("%s: %d %s", strings.ToLower(e.Method), e.StatusCode, e.ReasonPhrase)
+	if e.Reason != "" {
+		msg += fmt.Sprintf(" (%s)", e.Reason)
+	}
+	return msg
+}
+
+// Q850Cause cause of Q.850 Reason, false if the response has none
+func (e *ResponseError) Q850Cause() (int, bool) {
+	reasons, err := ParseReasons(e.Reason)
+	if err != nil {
+		return 0, false
+	}
+	for _, reason := range reasons {
+		if reason.Protocol == "Q.850" {
+			return reason.Cause, true
+		}
+	}
+	return 0, false
+}
+
+// ClientTransaction client transaction of a request sent by the softphone, RFC 3261 17.1
+type ClientTransaction struct {
+	Request   SipMessage
+	key       string
+	handler   func(SipMessage)
+	responses chan SipMessage
+	done      chan struct{}
+	// terminated is closed when the transaction doesn't accept responses anymore
+	terminated chan struct{}
+	response   SipMessage
+	err        error
+}
+
+// Done is closed when the transaction got the final response or timed out
+func (t *ClientTransaction) Done() <-chan struct{} {
+	return t.done
+}
+
+// Response waits for the final response of the transaction
+func (t *ClientTransaction) Response() (SipMessage, error) {
+	<-t.done
+	return t.response, t.err
+}
+
+// transactionKey key of transaction: branch of the topmost Via and CSeq method
+func transactionKey(sipMessage SipMessage) (string, error) {
+	via, err := sipMessage.Via()
+	if err != nil {
+		return "", err
+	}
+	cseq, err := sipMessage.CSeq()
+	if err != nil {
+		return "", err
+	}
+	if via.Branch() == "" {
+		return "", &ParseError{Reason: "Via has no branch"}
+	}
+	return fmt.Sprintf("%s %s", via.Branch(), cseq.Method), nil
+}
+
+// Request send request in a new client transaction, handler gets only the responses of this transaction in order
+func (s *Softphone) Request(request SipMessage, handler func(SipMessage)) (*ClientTransaction, error) {
+	key, err := transactionKey(request)
+	if err != nil {
+		return nil, err
+	}
+	t := &ClientTransaction{
+		Request:    request,
+		key:        key,
+		handler:    handler,
+		responses:  make(chan SipMessage, 16),
+		done:       make(chan struct{}),
+		terminated: make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	s.clientTransactions[key] = t
+	s.mu.Unlock()
+
+	if err := s.Send(request); err != nil {
+		s.removeClientTransaction(t)
+		return nil, err
+	}
+	go s.runClientTransaction(t)
+	return t, nil
+}
+
+func (s *Softphone) runClientTransaction(t *ClientTransaction) {
+	defer s.removeClientTransaction(t)
+	invite := t.Request.Method() == "INVITE"
+
+	timer := time.NewTimer(transactionTimeout)
+	defer timer.Stop()
+	timeout := timer.C
+	for {
+		select {
+		case response := <-t.responses:
+			if t.handler != nil {
+				t.handler(response)
+			}
+			if response.StatusCode() < 200 {
+				// Timer B doesn't fire in Proceeding state, Timer F does
+				if invite {
+					timeout = nil
+				}
+				continue
+			}
+			t.response = response
+			if invite && response.StatusCode() >= 300 {
+				s.ackFailure(t.Request, response)
+			}
+			close(t.done)
+			if invite {
+				s.lingerClientTransaction(t)
+			}
+			return
+		case <-timeout:
+			t.err = ErrTransactionTimeout
+			close(t.done)
+			return
+		}
+	}
+}
+
+// lingerClientTransaction keep finished INVITE transaction to pass retransmitted 2xx to the handler, RFC 6026,
+// and to ACK retransmitted non-2xx, RFC 3261 17.1.1.2
+func (s *Softphone) lingerClientTransaction(t *ClientTransaction) {
+	timer := time.NewTimer(transactionTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case response := <-t.responses:
+			code := response.StatusCode()
+			if code >= 300 {
+				s.ackFailure(t.Request, response)
+			} else if t.handler != nil && code >= 200 {
+				t.handler(response)
+			}
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// ackFailure send ACK for non-2xx final response to INVITE, it is a part of the INVITE transaction, RFC 3261 17.1.1.3
+func (s *Softphone) ackFailure(invite, response SipMessage) {
+	cseq, err := invite.CSeq()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	uri, err := invite.RequestURI()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	ack := SipMessage{
+		Subject: fmt.Sprintf("ACK %s SIP/2.0", uri),
+		Headers: invite.Headers.Filter("Via", "Route", "Max-Forwards", "From", "Call-ID"),
+	}
+	ack.Headers.Set("Via", invite.Headers.Get("Via"))
+	ack.Headers.Add("To", response.Headers.Get("To"))
+	ack.Headers.Add("CSeq", CSeq{Seq: cseq.Seq, Method: "ACK"}.String())
+	if err := s.Send(ack); err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *Softphone) removeClientTransaction(t *ClientTransaction) {
+	s.mu.Lock()
+	if s.clientTransactions[t.key] == t {
+		delete(s.clientTransactions, t.key)
+	}
+	s.mu.Unlock()
+	select {
+	case <-t.terminated:
+	default:
+		close(t.terminated)
+	}
+}
+
+// handleResponse pass response to its client transaction, stray responses are dropped
+func (s *Softphone) handleResponse(response SipMessage) {
+	key, err := transactionKey(response)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	s.mu.Lock()
+	t, ok := s.clientTransactions[key]
+	s.mu.Unlock()
+	if !ok {
+		if s.options.Verbose {
+			log.Println("response without transaction:", response.Subject)
+		}
+		return
+	}
+	select {
+	case t.responses <- response:
+	case <-t.terminated:
+	}
+}
+
+// authorize copy of request with credentials for the challenge of 401 or 407 response, new branch and next CSeq
+func (s *Softphone) authorize(request, response SipMessage) (SipMessage, error) {
+	challenge, header := "WWW-Authenticate", "Authorization"
+	if response.StatusCode() == 407 {
+		challenge, header = "Proxy-Authenticate", "Proxy-Authorization"
+	}
+	if !response.Headers.Has(challenge) {
+		return SipMessage{}, fmt.Errorf("%s without %s", response.Subject, challenge)
+	}
+	uri, err := request.RequestURI()
+	if err != nil {
+		return SipMessage{}, err
+	}
+	auth := sip.AuthFromValue(response.Headers.Get(challenge)).
+		SetMethod(request.Method()).
+		SetUri(uri.String()).
+		SetUsername(s.options.Username).
+		SetPassword(s.options.Password)
+	auth.SetResponse(auth.CalcResponse())
+
+	authorized := SipMessage{
+		Subject: request.Subject,
+		Headers: append(Headers{}, request.Headers...),
+		Body:    request.Body,
+	}
+	authorized.Headers.Set(header, auth.String())
+	authorized.Headers.Set("Via", s.via().String())
+	authorized.IncreaseSeq()
+	return authorized, nil
+}
+
+// requestWithAuth send request and wait for the final response, 401 and 407 challenges are answered once
+func (s *Softphone) requestWithAuth(request SipMessage) (SipMessage, SipMessage, error) {
+	t, err := s.Request(request, nil)
+	if err != nil {
+		return request, SipMessage{}, err
+	}
+	response, err := t.Response()
+	if err != nil {
+		return request, response, err
+	}
+	if code := response.StatusCode(); code != 401 && code != 407 {
+		return request, response, nil
+	}
+	request, err = s.authorize(request, response)
+	if err != nil {
+		return request, response, err
+	}
+	t, err = s.Request(request, nil)
+	if err != nil {
+		return request, SipMessage{}, err
+	}
+	response, err = t.Response()
+	return request, response, err
+}
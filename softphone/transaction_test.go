@@ -0,0 +1,18 @@
+package softphone
+
+import "testing"
+
+func TestResponseErrorQ850CauseOfSecondReason(t *testing.T) {
+	response := SipMessage{
+		Subject: "SIP/2.0 480 Temporarily Unavailable",
+		Headers: Headers{
+			{"CSeq", "1 INVITE"},
+			{"Reason", `SIP;cause=480;text="Temporarily Unavailable"`},
+			{"Reason", `Q.850;cause=19;text="NO_ANSWER"`},
+		},
+	}
+	err := newResponseError(response)
+	if cause, ok := err.Q850Cause(); !ok || cause != 19 {
+		t.Errorf("Q.850 cause %d %t of %q", cause, ok, err.Reason)
+	}
+}
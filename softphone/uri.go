@@ -0,0 +1,227 @@
+package softphone
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Param header or URI parameter, Value is empty for parameters without value
+type Param struct {
+	Name  string
+	Value string
+}
+
+// Params ordered parameters
+type Params []Param
+
+// Get returns the value of the parameter and whether it is present
+func (params Params) Get(name string) (string, bool) {
+	for _, param := range params {
+		if strings.EqualFold(param.Name, name) {
+			return param.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set replaces the value of the parameter or appends the parameter
+func (params *Params) Set(name, value string) {
+	for i, param := range *params {
+		if strings.EqualFold(param.Name, name) {
+			(*params)[i].Value = value
+			return
+		}
+	}
+	*params = append(*params, Param{Name: name, Value: value})
+}
+
+// Del removes the parameter
+func (params *Params) Del(name string) {
+	res := Params{}
+	for _, param := range *params {
+		if !strings.EqualFold(param.Name, name) {
+			res = append(res, param)
+		}
+	}
+	*params = res
+}
+
+// String parameters with leading semicolons, ";name=value;flag"
+func (params Params) String() string {
+	return params.join(";", true)
+}
+
+func (params Params) join(sep string, leading bool) string {
+	list := []string{}
+	for _, param := range params {
+		if param.Value == "" {
+			list = append(list, param.Name)
+		} else {
+			list = append(list, param.Name+"="+param.Value)
+		}
+	}
+	if len(list) == 0 {
+		return ""
+	}
+	if leading {
+		return sep + strings.Join(list, sep)
+	}
+	return strings.Join(list, sep)
+}
+
+// parseParams parse list of "name=value" parts, spaces around "=" are allowed
+func parseParams(parts []string) (Params, error) {
+	params := Params{}
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		tokens := strings.SplitN(part, "=", 2)
+		name := strings.TrimSpace(tokens[0])
+		if !isToken(name) {
+			return nil, &ParseError{Reason: fmt.Sprintf("invalid parameter %q", part)}
+		}
+		value := ""
+		if len(tokens) == 2 {
+			value = strings.TrimSpace(tokens[1])
+		}
+		params = append(params, Param{Name: name, Value: value})
+	}
+	return params, nil
+}
+
+// URI SIP, SIPS or tel URI. For tel URI User is the telephone number and Host is empty.
+// Header values are kept escaped.
+type URI struct {
+	Scheme   string
+	User     string
+	Password string
+	Host     string
+	// Port 0 if the URI has no port
+	Port    int
+	Params  Params
+	Headers Params
+}
+
+// ParseURI parse SIP, SIPS or tel URI
+func ParseURI(s string) (URI, error) {
+	s = strings.TrimSpace(s)
+	colon := strings.IndexByte(s, ':')
+	if colon <= 0 || strings.ContainsAny(s, " \t<>") {
+		return URI{}, &ParseError{Reason: fmt.Sprintf("invalid URI %q", s)}
+	}
+	uri := URI{Scheme: strings.ToLower(s[:colon])}
+	rest := s[colon+1:]
+
+	switch uri.Scheme {
+	case "tel":
+		parts := strings.Split(rest, ";")
+		if parts[0] == "" {
+			return URI{}, &ParseError{Reason: fmt.Sprintf("invalid tel URI %q", s)}
+		}
+		uri.User = parts[0]
+		params, err := parseParams(parts[1:])
+		if err != nil {
+			return URI{}, err
+		}
+		uri.Params = params
+	case "sip", "sips":
+		if question := strings.IndexByte(rest, '?'); question >= 0 {
+			headers, err := parseParams(strings.Split(rest[question+1:], "&"))
+			if err != nil {
+				return URI{}, err
+			}
+			uri.Headers = headers
+			rest = rest[:question]
+		}
+		// userinfo can't contain unescaped '@', RFC 3261 25.1, so '@' of a parameter doesn't move the split.
+		// The user part can contain ';', as in "sip:7042;phone-context=example.com@example.com".
+		if at := strings.IndexByte(rest, '@'); at >= 0 {
+			userinfo := strings.SplitN(rest[:at], ":", 2)
+			uri.User = userinfo[0]
+			if len(userinfo) == 2 {
+				uri.Password = userinfo[1]
+			}
+			rest = rest[at+1:]
+		}
+		parts := strings.Split(rest, ";")
+		host, port, err := parseHostPort(parts[0])
+		if err != nil {
+			return URI{}, err
+		}
+		uri.Host = host
+		uri.Port = port
+		params, err := parseParams(parts[1:])
+		if err != nil {
+			return URI{}, err
+		}
+		uri.Params = params
+	default:
+		return URI{}, &ParseError{Reason: fmt.Sprintf("unsupported URI scheme %q", uri.Scheme)}
+	}
+	return uri, nil
+}
+
+// String URI in "sip:user@host:port;params?headers" form
+func (uri URI) String() string {
+	if uri.Scheme == "tel" {
+		return "tel:" + uri.User + uri.Params.String()
+	}
+	res := uri.Scheme + ":"
+	if uri.User != "" {
+		res += uri.User
+		if uri.Password != "" {
+			res += ":" + uri.Password
+		}
+		res += "@"
+	}
+	res += uri.HostPort() + uri.Params.String()
+	if len(uri.Headers) > 0 {
+		res += "?" + uri.Headers.join("&", false)
+	}
+	return res
+}
+
+// HostPort host with port if the URI has one
+func (uri URI) HostPort() string {
+	if uri.Port == 0 {
+		return uri.Host
+	}
+	return fmt.Sprintf("%s:%d", uri.Host, uri.Port)
+}
+
+// parseHostPort parse "host", "host:port", "[ipv6]:port"
+func parseHostPort(s string) (string, int, error) {
+	s = strings.TrimSpace(s)
+	host := s
+	port := ""
+	if strings.HasPrefix(s, "[") {
+		end := strings.IndexByte(s, ']')
+		if end < 0 {
+			return "", 0, &ParseError{Reason: fmt.Sprintf("invalid host %q", s)}
+		}
+		host = s[:end+1]
+		if rest := s[end+1:]; rest != "" {
+			if rest[0] != ':' {
+				return "", 0, &ParseError{Reason: fmt.Sprintf("invalid host %q", s)}
+			}
+			port = rest[1:]
+		}
+	} else if colon := strings.IndexByte(s, ':'); colon >= 0 {
+		host = s[:colon]
+		port = s[colon+1:]
+	}
+	if host == "" || strings.ContainsAny(host, " \t") {
+		return "", 0, &ParseError{Reason: fmt.Sprintf("invalid host %q", s)}
+	}
+	if port == "" {
+		return host, 0, nil
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(port), 10, 16)
+	if err != nil {
+		return "", 0, &ParseError{Reason: fmt.Sprintf("invalid port %q", port)}
+	}
+	return host, int(n), nil
+}
@@ -0,0 +1,52 @@
+package softphone
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseURI(t *testing.T) {
+	tests := []struct {
+		uri      string
+		expected URI
+	}{
+		{"sip:alice@example.com", URI{Scheme: "sip", User: "alice", Host: "example.com", Params: Params{}}},
+		{"sips:alice:secret@[2001:db8::10]:5061;transport=tcp;lr", URI{
+			Scheme: "sips", User: "alice", Password: "secret", Host: "[2001:db8::10]", Port: 5061,
+			Params: Params{{"transport", "tcp"}, {"lr", ""}},
+		}},
+		{"sip:alice@example.com;maddr=bob@example.org", URI{
+			Scheme: "sip", User: "alice", Host: "example.com", Params: Params{{"maddr", "bob@example.org"}},
+		}},
+		{"sip:7042;phone-context=example.com@example.com;user=phone", URI{
+			Scheme: "sip", User: "7042;phone-context=example.com", Host: "example.com", Params: Params{{"user", "phone"}},
+		}},
+		{"sip:bob@example.com?Replaces=abc%40host%3Bto-tag%3D1&Subject=hi", URI{
+			Scheme: "sip", User: "bob", Host: "example.com", Params: Params{},
+			Headers: Params{{"Replaces", "abc%40host%3Bto-tag%3D1"}, {"Subject", "hi"}},
+		}},
+		{"sip:example.com:5060", URI{Scheme: "sip", Host: "example.com", Port: 5060, Params: Params{}}},
+		{"tel:+1-201-555-0123;ext=1", URI{Scheme: "tel", User: "+1-201-555-0123", Params: Params{{"ext", "1"}}}},
+	}
+	for _, test := range tests {
+		uri, err := ParseURI(test.uri)
+		if err != nil {
+			t.Errorf("%s: %v", test.uri, err)
+			continue
+		}
+		if !reflect.DeepEqual(uri, test.expected) {
+			t.Errorf("%s: parsed %#v, expected %#v", test.uri, uri, test.expected)
+		}
+		if s := uri.String(); s != test.uri {
+			t.Errorf("%s: string %s", test.uri, s)
+		}
+	}
+}
+
+func TestParseURIInvalid(t *testing.T) {
+	for _, s := range []string{"", "alice@example.com", "sip:", "sip:alice@", "http://example.com", "sip:a b@example.com", "sip:host:port"} {
+		if uri, err := ParseURI(s); err == nil {
+			t.Errorf("%q parsed as %#v", s, uri)
+		}
+	}
+}
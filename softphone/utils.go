@@ -3,40 +3,23 @@ package softphone
 import (
 	"log"
 
-	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 	"github.com/pion/sdp/v2"
+	"github.com/pion/webrtc/v3"
 )
 
-// Send send message via WebSocket
-func (s *Softphone) Send(sipMessage SipMessage, responseHandler func(string) bool) {
+// Send send message via WebSocket, User-Agent header is added if the message has none
+func (s *Softphone) Send(sipMessage SipMessage) error {
+	if !sipMessage.Headers.Has("User-Agent") {
+		sipMessage.Headers = append(append(Headers{}, sipMessage.Headers...), Header{"User-Agent", s.options.UserAgent})
+	}
 	stringMessage := sipMessage.ToString()
 	if s.options.Verbose {
 		log.Println("↑↑↑\n", stringMessage)
 	}
-	if responseHandler != nil {
-		var key string
-		key = s.addMessageListener(func(message string) {
-			done := responseHandler(message)
-			if done {
-				s.removeMessageListener(key)
-			}
-		})
-	}
-	err := s.conn.WriteMessage(1, []byte(stringMessage))
-	if err != nil {
-		log.Println(err)
-		return
-	}
-}
-
-func (s *Softphone) addMessageListener(messageListener func(string)) string {
-	key := uuid.New().String()
-	s.messageListeners[key] = messageListener
-	return key
-}
-
-func (s *Softphone) removeMessageListener(key string) {
-	delete(s.messageListeners, key)
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.conn.WriteMessage(websocket.TextMessage, []byte(stringMessage))
 }
 
 // patchFreeSwitchSDP mid and sendrecv required for pion
@@ -75,3 +58,60 @@ func patchFreeSwitchSDP(in string) string {
 	}
 	return string(out)
 }
+
+// sdpDirections direction attributes of SDP, RFC 3264 5.1
+var sdpDirections = map[string]webrtc.RTPTransceiverDirection{
+	"sendrecv": webrtc.RTPTransceiverDirectionSendrecv,
+	"sendonly": webrtc.RTPTransceiverDirectionSendonly,
+	"recvonly": webrtc.RTPTransceiverDirectionRecvonly,
+	"inactive": webrtc.RTPTransceiverDirectionInactive,
+}
+
+// sdpDirection direction of the first media, session level direction is the default, sendrecv if there is none.
+// Connection address 0.0.0.0 isn't treated as hold, WebRTC uses it with ICE.
+func sdpDirection(in string) webrtc.RTPTransceiverDirection {
+	parsed := &sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(in)); err != nil {
+		log.Println(err)
+		return webrtc.RTPTransceiverDirectionSendrecv
+	}
+	direction := webrtc.RTPTransceiverDirectionSendrecv
+	attributes := parsed.Attributes
+	if len(parsed.MediaDescriptions) > 0 {
+		attributes = append(append([]sdp.Attribute{}, attributes...), parsed.MediaDescriptions[0].Attributes...)
+	}
+	for _, attr := range attributes {
+		if d, ok := sdpDirections[attr.Key]; ok {
+			direction = d
+		}
+	}
+	return direction
+}
+
+// setSDPDirection replace direction attributes of all media with direction
+func setSDPDirection(in string, direction webrtc.RTPTransceiverDirection) string {
+	parsed := &sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(in)); err != nil {
+		log.Println(err)
+		return in
+	}
+	parsed.Attributes = withoutDirection(parsed.Attributes)
+	for _, media := range parsed.MediaDescriptions {
+		media.Attributes = append(withoutDirection(media.Attributes), sdp.NewPropertyAttribute(direction.String()))
+	}
+	out, err := parsed.Marshal()
+	if err != nil {
+		panic(err)
+	}
+	return string(out)
+}
+
+func withoutDirection(attributes []sdp.Attribute) []sdp.Attribute {
+	res := []sdp.Attribute{}
+	for _, attr := range attributes {
+		if _, ok := sdpDirections[attr.Key]; !ok {
+			res = append(res, attr)
+		}
+	}
+	return res
+}